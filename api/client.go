@@ -0,0 +1,80 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// Client calls the api of a Server.
+type Client struct {
+	base   string
+	token  string
+	client *http.Client
+}
+
+func NewClient(config Config) *Client {
+	network, addr := config.network()
+	c := &Client{
+		base:  "http://" + addr,
+		token: config.Token,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+	if network == "unix" {
+		c.base = "http://unix"
+		c.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", addr)
+			},
+		}
+	}
+	return c
+}
+
+// Call calls the method with the params, and decodes the result into reply if not nil.
+func (c *Client) Call(method string, params any, reply any) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("encode params error: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, c.base+Prefix+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	rsp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(rsp.Body, maxBodySize))
+	if err != nil {
+		return err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		var e errorReply
+		if json.Unmarshal(b, &e) == nil && e.Error != "" {
+			return fmt.Errorf("%s: %s", method, e.Error)
+		}
+		return fmt.Errorf("%s: %s", method, rsp.Status)
+	}
+	if reply == nil {
+		return nil
+	}
+	if err = json.Unmarshal(b, reply); err != nil {
+		return fmt.Errorf("decode reply error: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+// Package api serves the operations of a core over a local HTTP/JSON api,
+// so the core can be driven without the Ratte host.
+//
+// Every operation is a POST to /api/v1/<Method> with the params of the method
+// in json, the result is returned in json with status 200, and an error
+// is returned as {"Error": "..."} with a status other than 200.
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/InazumaV/Ratte-Interface/core"
+	"github.com/goccy/go-json"
+)
+
+const Prefix = "/api/v1/"
+
+// maxBodySize limits the size of a request
+const maxBodySize = 16 << 20
+
+type Config struct {
+	// Listen is the address of the api, such as "127.0.0.1:10085",
+	// or the path of a unix socket with the prefix "unix:"
+	Listen string `json:"Listen"`
+	// Token authenticates the requests by "Authorization: Bearer <token>",
+	// required unless listening on a unix socket
+	Token string `json:"Token"`
+}
+
+// network returns the network and the address of Listen
+func (c *Config) network() (string, string) {
+	if p, ok := strings.CutPrefix(c.Listen, "unix:"); ok {
+		return "unix", p
+	}
+	return "tcp", c.Listen
+}
+
+// StartParams is the params of Start, Config is the config of the core,
+// or a string of it in the formats other than json.
+type StartParams struct {
+	DataPath string
+	Config   json.RawMessage
+}
+
+func (p *StartParams) config() []byte {
+	var s string
+	if json.Unmarshal(p.Config, &s) == nil {
+		return []byte(s)
+	}
+	return p.Config
+}
+
+type DelNodeParams struct {
+	Name string
+}
+
+type GetUserTrafficReply struct {
+	Up   int64
+	Down int64
+}
+
+type InfoReply struct {
+	Type      string
+	Protocols []string
+}
+
+type errorReply struct {
+	Error string
+}
+
+type Server struct {
+	core   core.Core
+	config Config
+	mux    *http.ServeMux
+	srv    *http.Server
+	ln     net.Listener
+}
+
+func NewServer(c core.Core, config Config) *Server {
+	s := &Server{
+		core:   c,
+		config: config,
+		mux:    http.NewServeMux(),
+	}
+	handle(s, "Start", func(p *StartParams) (any, error) {
+		return nil, s.core.Start(p.DataPath, p.config())
+	})
+	handle(s, "Close", func(*struct{}) (any, error) {
+		return nil, s.core.Close()
+	})
+	handle(s, "AddNode", func(p *core.AddNodeParams) (any, error) {
+		return nil, s.core.AddNode(p)
+	})
+	handle(s, "DelNode", func(p *DelNodeParams) (any, error) {
+		return nil, s.core.DelNode(p.Name)
+	})
+	handle(s, "AddUsers", func(p *core.AddUsersParams) (any, error) {
+		return nil, s.core.AddUsers(p)
+	})
+	handle(s, "DelUsers", func(p *core.DelUsersParams) (any, error) {
+		return nil, s.core.DelUsers(p)
+	})
+	handle(s, "GetUserTraffic", func(p *core.GetUserTrafficParams) (any, error) {
+		r := s.core.GetUserTraffic(p)
+		if r.Err != nil {
+			return nil, r.Err
+		}
+		return &GetUserTrafficReply{Up: r.Up, Down: r.Down}, nil
+	})
+	handle(s, "ResetUserTraffic", func(p *core.ResetUserTrafficParams) (any, error) {
+		return nil, s.core.ResetUserTraffic(p)
+	})
+	handle(s, "CustomMethod", func(p *core.CustomMethodParams) (any, error) {
+		var reply any
+		err := s.core.CustomMethod(p.Method, p.Args, &reply)
+		return reply, err
+	})
+	handle(s, "Info", func(*struct{}) (any, error) {
+		return &InfoReply{
+			Type:      s.core.Type(),
+			Protocols: s.core.Protocols(),
+		}, nil
+	})
+	return s
+}
+
+// handle registers the method,
+// the params are decoded from the body and the result is written as json.
+func handle[P any](s *Server, method string, f func(p *P) (any, error)) {
+	s.mux.HandleFunc("POST "+Prefix+method, func(w http.ResponseWriter, r *http.Request) {
+		p := new(P)
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, &errorReply{Error: err.Error()})
+			return
+		}
+		if len(body) > 0 {
+			if err = json.Unmarshal(body, p); err != nil {
+				writeJSON(w, http.StatusBadRequest, &errorReply{
+					Error: fmt.Sprintf("decode params error: %s", err),
+				})
+				return
+			}
+		}
+		reply, err := f(p)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, &errorReply{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, reply)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		status = http.StatusInternalServerError
+		b, _ = json.Marshal(&errorReply{Error: fmt.Sprintf("encode reply error: %s", err)})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(b)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.config.Token != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Token)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, &errorReply{Error: "unauthorized"})
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// Start listens on the address of the config and serves the api in background.
+func (s *Server) Start() error {
+	network, addr := s.config.network()
+	if network == "tcp" && s.config.Token == "" {
+		return errors.New("token is required to listen on tcp")
+	}
+	var ln net.Listener
+	var err error
+	if network == "unix" {
+		if err = removeSocket(addr); err != nil {
+			return err
+		}
+		ln, err = listenUnix(addr)
+	} else {
+		ln, err = net.Listen(network, addr)
+	}
+	if err != nil {
+		return fmt.Errorf("listen api error: %w", err)
+	}
+	s.ln = ln
+	s.srv = &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		_ = s.srv.Serve(ln)
+	}()
+	return nil
+}
+
+// removeSocket removes the socket left at addr by the last run,
+// anything else at addr is kept.
+func removeSocket(addr string) error {
+	fi, err := os.Lstat(addr)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("stat api socket error: %w", err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", addr)
+	}
+	return os.Remove(addr)
+}
+
+// Addr returns the address listened on, nil if not started.
+func (s *Server) Addr() net.Addr {
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Addr()
+}
+
+func (s *Server) Close() error {
+	if s.srv == nil {
+		return nil
+	}
+	return s.srv.Close()
+}
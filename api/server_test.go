@@ -0,0 +1,168 @@
+package api
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/InazumaV/Ratte-Interface/core"
+)
+
+type testCore struct {
+	nodes  map[string]*core.AddNodeParams
+	config string
+}
+
+func (c *testCore) CustomMethod(method string, args any, reply *any) error {
+	if method != "Echo" {
+		return errors.New("unsupported method: " + method)
+	}
+	*reply = args
+	return nil
+}
+
+func (c *testCore) Start(_ string, config []byte) error {
+	c.config = string(config)
+	return nil
+}
+
+func (c *testCore) Close() error { return nil }
+
+func (c *testCore) AddNode(p *core.AddNodeParams) error {
+	c.nodes[p.Name] = p
+	return nil
+}
+
+func (c *testCore) DelNode(name string) error {
+	if _, ok := c.nodes[name]; !ok {
+		return errors.New("no such node")
+	}
+	delete(c.nodes, name)
+	return nil
+}
+
+func (c *testCore) AddUsers(*core.AddUsersParams) error { return nil }
+
+func (c *testCore) GetUserTraffic(p *core.GetUserTrafficParams) *core.GetUserTrafficResponse {
+	return &core.GetUserTrafficResponse{Up: 1, Down: 2}
+}
+
+func (c *testCore) ResetUserTraffic(*core.ResetUserTrafficParams) error { return nil }
+
+func (c *testCore) DelUsers(*core.DelUsersParams) error { return nil }
+
+func (c *testCore) Protocols() []string { return []string{"vless"} }
+
+func (c *testCore) Type() string { return "test" }
+
+func startServer(t *testing.T, config Config) (*testCore, *Server) {
+	c := &testCore{nodes: map[string]*core.AddNodeParams{}}
+	s := NewServer(c, config)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return c, s
+}
+
+func TestServer(t *testing.T) {
+	c, s := startServer(t, Config{Listen: "127.0.0.1:0", Token: "t"})
+	cl := NewClient(Config{Listen: s.Addr().String(), Token: "t"})
+
+	err := cl.Call("Start", &StartParams{Config: []byte(`"Limiter:\n  Burst: 1\n"`)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.config != "Limiter:\n  Burst: 1\n" {
+		t.Errorf("config = %q", c.config)
+	}
+	if err = cl.Call("AddNode", &core.AddNodeParams{Name: "n1"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if c.nodes["n1"] == nil {
+		t.Error("node is not added")
+	}
+	var traffic GetUserTrafficReply
+	err = cl.Call("GetUserTraffic", &core.GetUserTrafficParams{NodeName: "n1", Username: "u"}, &traffic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if traffic.Up != 1 || traffic.Down != 2 {
+		t.Errorf("traffic = %+v", traffic)
+	}
+	var echo map[string]any
+	err = cl.Call("CustomMethod", &core.CustomMethodParams{Method: "Echo", Args: map[string]any{"a": "b"}}, &echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if echo["a"] != "b" {
+		t.Errorf("echo = %v", echo)
+	}
+	if err = cl.Call("DelNode", &DelNodeParams{Name: "n2"}, nil); err == nil || err.Error() != "DelNode: no such node" {
+		t.Errorf("error = %v", err)
+	}
+	if err = cl.Call("Nope", nil, nil); err == nil {
+		t.Error("called an unknown method")
+	}
+}
+
+func TestServer_Unauthorized(t *testing.T) {
+	_, s := startServer(t, Config{Listen: "127.0.0.1:0", Token: "t"})
+	for _, token := range []string{"", "x"} {
+		err := NewClient(Config{Listen: s.Addr().String(), Token: token}).Call("Info", nil, nil)
+		if err == nil {
+			t.Errorf("called with token %q", token)
+		}
+	}
+}
+
+func TestServer_Unix(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "api.sock")
+	// a socket left by the last run is replaced
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: sock, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.SetUnlinkOnClose(false)
+	_ = ln.Close()
+	startServer(t, Config{Listen: "unix:" + sock})
+	var info InfoReply
+	if err = NewClient(Config{Listen: "unix:" + sock}).Call("Info", nil, &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Type != "test" {
+		t.Errorf("info = %+v", info)
+	}
+	fi, err := os.Stat(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0o600 {
+		t.Errorf("mode of socket = %o", perm)
+	}
+}
+
+func TestServer_UnixNotSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.sock")
+	if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(&testCore{}, Config{Listen: "unix:" + path})
+	if err := s.Start(); err == nil {
+		_ = s.Close()
+		t.Error("listened on a path of a file")
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != "data" {
+		t.Errorf("file = %q, %v", b, err)
+	}
+}
+
+func TestServer_TcpWithoutToken(t *testing.T) {
+	s := NewServer(&testCore{}, Config{Listen: "127.0.0.1:0"})
+	if err := s.Start(); err == nil {
+		_ = s.Close()
+		t.Error("listened on tcp without a token")
+	}
+}
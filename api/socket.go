@@ -0,0 +1,16 @@
+//go:build !windows && !plan9
+
+package api
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenUnix listens on the unix socket at addr, only the owner can connect to it.
+// The socket is created with the mode under the umask, so it is never open to others.
+func listenUnix(addr string) (net.Listener, error) {
+	old := syscall.Umask(0o177)
+	defer syscall.Umask(old)
+	return net.Listen("unix", addr)
+}
@@ -0,0 +1,11 @@
+//go:build windows || plan9
+
+package api
+
+import (
+	"net"
+)
+
+func listenUnix(addr string) (net.Listener, error) {
+	return net.Listen("unix", addr)
+}
@@ -0,0 +1,68 @@
+package xray
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/xtls/xray-core/app/commander"
+	coreConf "github.com/xtls/xray-core/infra/conf"
+)
+
+// apiTag is the tag of the commander of xray
+const apiTag = "api"
+
+// apiServices are the services of xray which can be enabled
+var apiServices = []string{
+	"HandlerService",
+	"LoggerService",
+	"StatsService",
+	"ObservatoryService",
+	"RoutingService",
+	"ReflectionService",
+}
+
+// ApiConfig turns on the grpc services of xray,
+// so the standard tooling such as "xray api statsquery" works against the core.
+type ApiConfig struct {
+	// Listen is the address of the services, it must be a loopback one
+	// such as "127.0.0.1:10085", since the services are not authenticated
+	Listen string `json:"Listen"`
+	// Services are the names of the services enabled, such as "StatsService"
+	Services []string `json:"Services"`
+}
+
+// Build builds the config of the commander of xray.
+func (c *ApiConfig) Build() (*commander.Config, error) {
+	host, _, err := net.SplitHostPort(c.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %s: %w", c.Listen, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("listen address %s is not a loopback one", c.Listen)
+	}
+	if len(c.Services) == 0 {
+		return nil, errors.New("no service is enabled")
+	}
+	for _, s := range c.Services {
+		if !containsFold(apiServices, s) {
+			return nil, fmt.Errorf("unsupported service %s, must be one of %s",
+				s, strings.Join(apiServices, ", "))
+		}
+	}
+	return (&coreConf.APIConfig{
+		Tag:      apiTag,
+		Listen:   c.Listen,
+		Services: c.Services,
+	}).Build()
+}
+
+func containsFold(ss []string, s string) bool {
+	for _, v := range ss {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
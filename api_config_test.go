@@ -0,0 +1,68 @@
+package xray
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	statsService "github.com/xtls/xray-core/app/stats/command"
+	statsFeature "github.com/xtls/xray-core/features/stats"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestApiConfig_Build(t *testing.T) {
+	for _, c := range []ApiConfig{
+		{Listen: "0.0.0.0:10085", Services: []string{"StatsService"}},
+		{Listen: "127.0.0.1", Services: []string{"StatsService"}},
+		{Listen: "127.0.0.1:10085"},
+		{Listen: "127.0.0.1:10085", Services: []string{"StatsService", "Nope"}},
+	} {
+		if _, err := c.Build(); err == nil {
+			t.Errorf("built %+v", c)
+		}
+	}
+	cc, err := (&ApiConfig{Listen: "[::1]:10085", Services: []string{"statsservice", "HandlerService"}}).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cc.Service) != 2 {
+		t.Errorf("services = %v", cc.Service)
+	}
+}
+
+func TestXray_Api(t *testing.T) {
+	port := freePort(t)
+	xr := NewXray()
+	err := xr.Start("./", []byte(fmt.Sprintf(`{
+	"Api": {"Listen": "127.0.0.1:%d", "Services": ["StatsService"]}
+}`, port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer xr.Close()
+	counter, err := statsFeature.GetOrRegisterCounter(xr.shm, "user>>>[u1](n1)>>>traffic>>>uplink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter.Set(42)
+
+	conn, err := grpc.NewClient(fmt.Sprintf("127.0.0.1:%d", port),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rsp, err := statsService.NewStatsServiceClient(conn).GetStats(ctx, &statsService.GetStatsRequest{
+		Name: "user>>>[u1](n1)>>>traffic>>>uplink",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.Stat.Value != 42 {
+		t.Errorf("stat = %v", rsp.Stat)
+	}
+}
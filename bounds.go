@@ -0,0 +1,150 @@
+package xray
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/InazumaV/Ratte-Core-Xray/common"
+	xc "github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/features/inbound"
+	"github.com/xtls/xray-core/features/outbound"
+	coreConf "github.com/xtls/xray-core/infra/conf"
+)
+
+// isNodeTag reports whether the tag is the inbound or the default outbound of a node,
+// they are managed by AddNode and DelNode only.
+func (c *Xray) isNodeTag(tag string) bool {
+	for name := range c.nodes.Items() {
+		if tag == name || tag == common.FormatDefaultOutboundName(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Xray) checkCustomTag(tag string) error {
+	if tag == "" {
+		return errors.New("tag is empty")
+	}
+	if c.isNodeTag(tag) {
+		return fmt.Errorf("tag %s is used by a node", tag)
+	}
+	return nil
+}
+
+// AddInboundParams is a custom inbound not belonging to a node,
+// Config is an inbound of xray in json, yaml or toml.
+type AddInboundParams struct {
+	Config string `mapstructure:"Config"`
+}
+
+func (c *Xray) AddInbound(p *AddInboundParams) error {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	return c.addInbound(p.Config)
+}
+
+// addInbound adds the custom inbound of the config, the caller holds c.access.
+func (c *Xray) addInbound(config string) error {
+	in := &coreConf.InboundDetourConfig{}
+	err := decodeConfig("", []byte(config), in)
+	if err != nil {
+		return fmt.Errorf("decode inbound config error: %w", err)
+	}
+	if err = c.checkCustomTag(in.Tag); err != nil {
+		return err
+	}
+	ic, err := in.Build()
+	if err != nil {
+		return fmt.Errorf("build inbound(tag=%s) config error: %w", in.Tag, err)
+	}
+	rawH, err := xc.CreateObject(c.Server, ic)
+	if err != nil {
+		return err
+	}
+	h, ok := rawH.(inbound.Handler)
+	if !ok {
+		return fmt.Errorf("not an InboundHandler: %s", in.Tag)
+	}
+	if err = c.ihm.AddHandler(context.Background(), h); err != nil {
+		return fmt.Errorf("add inbound handler error: %s", err)
+	}
+	c.customInbounds.Set(in.Tag, config)
+	return nil
+}
+
+type RemoveInboundParams struct {
+	Tag string `mapstructure:"Tag"`
+}
+
+func (c *Xray) RemoveInbound(p *RemoveInboundParams) error {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	if err := c.checkCustomTag(p.Tag); err != nil {
+		return err
+	}
+	if err := c.ihm.RemoveHandler(context.Background(), p.Tag); err != nil {
+		return fmt.Errorf("remove inbound %s error: %v", p.Tag, err)
+	}
+	c.customInbounds.Remove(p.Tag)
+	return nil
+}
+
+// AddOutboundParams is a custom outbound not belonging to a node,
+// Config is an outbound of xray in json, yaml or toml.
+type AddOutboundParams struct {
+	Config string `mapstructure:"Config"`
+}
+
+func (c *Xray) AddOutbound(p *AddOutboundParams) error {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	return c.addOutbound(p.Config)
+}
+
+// addOutbound adds the custom outbound of the config, the caller holds c.access.
+func (c *Xray) addOutbound(config string) error {
+	out := &coreConf.OutboundDetourConfig{}
+	err := decodeConfig("", []byte(config), out)
+	if err != nil {
+		return fmt.Errorf("decode outbound config error: %w", err)
+	}
+	if err = c.checkCustomTag(out.Tag); err != nil {
+		return err
+	}
+	oc, err := out.Build()
+	if err != nil {
+		return fmt.Errorf("build outbound(tag=%s) config error: %w", out.Tag, err)
+	}
+	rawH, err := xc.CreateObject(c.Server, oc)
+	if err != nil {
+		return err
+	}
+	h, ok := rawH.(outbound.Handler)
+	if !ok {
+		return fmt.Errorf("not an OutboundHandler: %s", out.Tag)
+	}
+	if err = c.ohm.AddHandler(context.Background(), h); err != nil {
+		return fmt.Errorf("add outbound handler error: %s", err)
+	}
+	c.customOutbounds.Set(out.Tag, config)
+	return nil
+}
+
+type RemoveOutboundParams struct {
+	Tag string `mapstructure:"Tag"`
+}
+
+func (c *Xray) RemoveOutbound(p *RemoveOutboundParams) error {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	if err := c.checkCustomTag(p.Tag); err != nil {
+		return err
+	}
+	if err := c.ohm.RemoveHandler(context.Background(), p.Tag); err != nil {
+		return fmt.Errorf("remove outbound %s error: %v", p.Tag, err)
+	}
+	c.customOutbounds.Remove(p.Tag)
+	return nil
+}
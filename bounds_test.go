@@ -0,0 +1,106 @@
+package xray
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func dial(port int) error {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), time.Second)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func TestXray_Start_StaticInbound(t *testing.T) {
+	port := freePort(t)
+	xr := NewXray()
+	err := xr.Start("./", []byte(fmt.Sprintf(`{
+	"Inbound": [{"tag": "static-socks", "listen": "127.0.0.1", "port": %d, "protocol": "socks"}]
+}`, port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer xr.Close()
+	if err = dial(port); err != nil {
+		t.Errorf("static inbound is not listening: %v", err)
+	}
+}
+
+func TestXray_Start_Unwind(t *testing.T) {
+	port := freePort(t)
+	out := log.StandardLogger().Out
+	xr := NewXray()
+	err := xr.Start(t.TempDir(), []byte(fmt.Sprintf(`{
+	"Log": {"Sinks": [{"Type": "file", "File": {"Path": "core.log"}}]},
+	"Inbound": [{"tag": "static-socks", "listen": "127.0.0.1", "port": %d, "protocol": "socks"}],
+	"ControlSocket": "missing/ctl.sock"
+}`, port)))
+	if err == nil {
+		_ = xr.Close()
+		t.Fatal("started with a control socket in a missing directory")
+	}
+	if err = dial(port); err == nil {
+		t.Error("static inbound is still listening after a failed start")
+	}
+	if log.StandardLogger().Out != out {
+		t.Error("the output of logrus is not restored after a failed start")
+	}
+	if xr.Server != nil || xr.ips != nil || xr.logSinks != nil {
+		t.Error("the failed start is not undone")
+	}
+}
+
+func TestXray_AddInbound_AND_RemoveInbound(t *testing.T) {
+	port := freePort(t)
+	err := x.AddInbound(&AddInboundParams{Config: fmt.Sprintf(`
+tag: health-socks
+listen: 127.0.0.1
+port: %d
+protocol: socks
+`, port)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = dial(port); err != nil {
+		t.Errorf("custom inbound is not listening: %v", err)
+	}
+	if err = x.RemoveInbound(&RemoveInboundParams{Tag: "health-socks"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = dial(port); err == nil {
+		t.Error("custom inbound is still listening after removed")
+	}
+}
+
+func TestXray_AddOutbound_AND_RemoveOutbound(t *testing.T) {
+	err := x.AddOutbound(&AddOutboundParams{Config: `{"tag": "custom-direct", "protocol": "freedom"}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.ohm.GetHandler("custom-direct") == nil {
+		t.Fatal("custom outbound is not added")
+	}
+	if err = x.RemoveOutbound(&RemoveOutboundParams{Tag: "custom-direct"}); err != nil {
+		t.Fatal(err)
+	}
+	if x.ohm.GetHandler("custom-direct") != nil {
+		t.Error("custom outbound is not removed")
+	}
+}
+
+func TestXray_RemoveOutbound_NodeTag(t *testing.T) {
+	x.nodes.Set("tag-node", &node{})
+	defer x.nodes.Remove("tag-node")
+	if err := x.RemoveInbound(&RemoveInboundParams{Tag: "tag-node"}); err == nil {
+		t.Error("removed the inbound of a node")
+	}
+	if err := x.AddOutbound(&AddOutboundParams{Config: `{"tag": "tag-node", "protocol": "freedom"}`}); err == nil {
+		t.Error("added an outbound with the tag of a node")
+	}
+}
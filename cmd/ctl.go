@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	xray "github.com/InazumaV/Ratte-Core-Xray"
+	"github.com/InazumaV/Ratte-Core-Xray/api"
+	"github.com/InazumaV/Ratte-Core-Xray/dispatcher"
+	"github.com/InazumaV/Ratte-Interface/core"
+)
+
+// defaultSocket is the control socket the cli talks to by default,
+// the core serves it only with the same ControlSocket in its config
+const defaultSocket = "ratte-core-xray.sock"
+
+// ctlCommands are the subcommands talking to a running core by the control socket
+var ctlCommands = map[string]func(c *api.Client, args []string) error{
+	"nodes":   ctlNodes,
+	"users":   ctlUsers,
+	"traffic": ctlTraffic,
+	"online":  ctlOnline,
+	"kick":    ctlKick,
+	"reload":  ctlReload,
+	"health":  ctlHealth,
+}
+
+func runCtl(name string, args []string) int {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `usage: %s %s [flags] [args]
+The core serves the control socket only if ControlSocket is set in its config,
+the socket is at ControlSocket under the data path of the core.
+Set ControlSocket to %q or pass it to -s, and pass the data path to -d.
+`, filepath.Base(os.Args[0]), name, defaultSocket)
+		fs.PrintDefaults()
+	}
+	dataPath := fs.String("d", envOr("RATTE_DATA_PATH", "./"), "data path of the core, a relative socket is under it")
+	socket := fs.String("s", envOr("RATTE_CONTROL_SOCKET", defaultSocket),
+		`control socket of the core, or the address of the api such as "127.0.0.1:10085"`)
+	tokenEnv := fs.String("token-env", "RATTE_API_TOKEN", "environment variable of the token of the api")
+	_ = fs.Parse(args)
+	listen := *socket
+	if !strings.Contains(listen, ":") {
+		if !filepath.IsAbs(listen) {
+			listen = filepath.Join(*dataPath, listen)
+		}
+		listen = "unix:" + listen
+	}
+	c := api.NewClient(api.Config{
+		Listen: listen,
+		Token:  os.Getenv(*tokenEnv),
+	})
+	if err := ctlCommands[name](c, fs.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func custom(c *api.Client, method string, args any, reply any) error {
+	return c.Call("CustomMethod", &core.CustomMethodParams{
+		Method: method,
+		Args:   args,
+	}, reply)
+}
+
+func table(header ...string) *tabwriter.Writer {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	return w
+}
+
+// nodes list
+func ctlNodes(c *api.Client, args []string) error {
+	if len(args) != 1 || args[0] != "list" {
+		return errors.New("usage: nodes list")
+	}
+	var ns []xray.NodeStatus
+	if err := custom(c, xray.MethodListNodes, nil, &ns); err != nil {
+		return err
+	}
+	w := table("NAME", "TYPE", "PORT", "USERS", "CONNS")
+	for _, n := range ns {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\n", n.Name, n.Type, n.Port, n.Users, n.Conns)
+	}
+	return w.Flush()
+}
+
+func listUsers(c *api.Client, node string) ([]xray.UserStatus, error) {
+	var us []xray.UserStatus
+	err := custom(c, xray.MethodListUsers, &xray.ListUsersParams{NodeName: node}, &us)
+	return us, err
+}
+
+// users list <node>
+func ctlUsers(c *api.Client, args []string) error {
+	if len(args) != 2 || args[0] != "list" {
+		return errors.New("usage: users list <node>")
+	}
+	us, err := listUsers(c, args[1])
+	if err != nil {
+		return err
+	}
+	w := table("NAME", "CONNS", "IPS")
+	for _, u := range us {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", u.Name, u.Conns, strings.Join(u.Ips, ","))
+	}
+	return w.Flush()
+}
+
+// traffic <node> [user]
+func ctlTraffic(c *api.Client, args []string) error {
+	var us []xray.UserStatus
+	switch len(args) {
+	case 1:
+		var err error
+		if us, err = listUsers(c, args[0]); err != nil {
+			return err
+		}
+	case 2:
+		var t api.GetUserTrafficReply
+		err := c.Call("GetUserTraffic", &core.GetUserTrafficParams{
+			NodeName: args[0],
+			Username: args[1],
+		}, &t)
+		if err != nil {
+			return err
+		}
+		us = []xray.UserStatus{{Name: args[1], Up: t.Up, Down: t.Down}}
+	default:
+		return errors.New("usage: traffic <node> [user]")
+	}
+	w := table("NAME", "UP", "DOWN")
+	for _, u := range us {
+		fmt.Fprintf(w, "%s\t%d\t%d\n", u.Name, u.Up, u.Down)
+	}
+	return w.Flush()
+}
+
+// online <node>
+func ctlOnline(c *api.Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: online <node>")
+	}
+	var us []xray.OnlineUser
+	if err := custom(c, xray.MethodGetOnline, &xray.GetOnlineParams{NodeName: args[0]}, &us); err != nil {
+		return err
+	}
+	w := table("NAME", "CONNS", "IPS")
+	for _, u := range us {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", u.Name, u.Conns, strings.Join(u.Ips, ","))
+	}
+	return w.Flush()
+}
+
+// kick <node> <user>
+func ctlKick(c *api.Client, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: kick <node> <user>")
+	}
+	var n int
+	err := custom(c, xray.MethodKickUser, &xray.KickUserParams{
+		NodeName: args[0],
+		Username: args[1],
+	}, &n)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("kicked %d sessions\n", n)
+	return nil
+}
+
+// reload
+func ctlReload(c *api.Client, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: reload")
+	}
+	if err := custom(c, xray.MethodReload, nil, nil); err != nil {
+		return err
+	}
+	fmt.Println("reloaded")
+	return nil
+}
+
+// health
+func ctlHealth(c *api.Client, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: health")
+	}
+	var hs []dispatcher.OutboundHealth
+	if err := custom(c, xray.MethodGetOutboundHealth, nil, &hs); err != nil {
+		return err
+	}
+	w := table("OUTBOUND", "ALIVE", "DELAY", "LAST SEEN", "ERROR")
+	for _, h := range hs {
+		seen := "-"
+		if !h.LastSeen.IsZero() {
+			seen = h.LastSeen.Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "%s\t%t\t%dms\t%s\t%s\n", h.Tag, h.Alive, h.Delay, seen, h.LastError)
+	}
+	return w.Flush()
+}
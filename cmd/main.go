@@ -1,12 +1,25 @@
 package main
 
 import (
+	"os"
+
 	xray "github.com/InazumaV/Ratte-Core-Xray"
 	"github.com/InazumaV/Ratte-Interface/core"
 	log "github.com/sirupsen/logrus"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		case "serve":
+			os.Exit(runServe(os.Args[2:]))
+		}
+		if _, ok := ctlCommands[os.Args[1]]; ok {
+			os.Exit(runCtl(os.Args[1], os.Args[2:]))
+		}
+	}
 	c, err := core.NewServer(nil, xray.NewXray())
 	if err != nil {
 		log.Fatalln(err)
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	xray "github.com/InazumaV/Ratte-Core-Xray"
+	"github.com/InazumaV/Ratte-Core-Xray/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// runServe runs the core without the Ratte host,
+// it is driven by the api until interrupted.
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := fs.String("c", "", "path of the xray config in json, yaml or toml, the core is started by the api if empty")
+	dataPath := fs.String("d", "./", "path the files in the config are relative to")
+	listen := fs.String("l", "unix:"+defaultSocket, `address of the api, such as "127.0.0.1:10085" or "unix:<path>"`)
+	tokenEnv := fs.String("token-env", "RATTE_API_TOKEN", "environment variable of the token of the api")
+	_ = fs.Parse(args)
+
+	c := xray.NewXray()
+	if *configPath != "" {
+		config, err := os.ReadFile(*configPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if err = c.Start(*dataPath, config); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer c.Close()
+	}
+	s := api.NewServer(c, api.Config{
+		Listen: *listen,
+		Token:  os.Getenv(*tokenEnv),
+	})
+	if err := s.Start(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer s.Close()
+	log.Infof("api is listening on %s", s.Addr())
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+	return 0
+}
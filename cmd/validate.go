@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	xray "github.com/InazumaV/Ratte-Core-Xray"
+	"github.com/InazumaV/Ratte-Interface/core"
+)
+
+// runValidate checks a config and node templates without starting them,
+// it prints every error found and exits with 1 if there is any.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := fs.String("c", "", "path of the xray config in json, yaml or toml")
+	dataPath := fs.String("d", "./", "path the files in the config are relative to")
+	nodesPath := fs.String("n", "", "path of the list of nodes to add, optional")
+	_ = fs.Parse(args)
+	if *configPath == "" {
+		fs.Usage()
+		return 2
+	}
+	config, err := os.ReadFile(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var nodes []*core.AddNodeParams
+	if *nodesPath != "" {
+		data, err := os.ReadFile(*nodesPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		nodes, err = xray.DecodeNodes(*nodesPath, data)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	errs, err := xray.ValidateConfig(*dataPath, config, nodes)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, e := range errs {
+		if e.Path == "" {
+			fmt.Printf("%s: %s\n", *configPath, e.Message)
+			continue
+		}
+		fmt.Printf("%s: %s: %s\n", *configPath, e.Path, e.Message)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	fmt.Println("config is valid")
+	return 0
+}
@@ -1,6 +1,9 @@
 package common
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 func FormatDefaultOutboundName(name string) string {
 	return fmt.Sprintf("%s_out", name)
@@ -9,3 +12,15 @@ func FormatDefaultOutboundName(name string) string {
 func FormatUserEmail(nodeName, username string) string {
 	return fmt.Sprintf("[%s](%s)", username, nodeName)
 }
+
+// ParseUserEmail splits an email built by FormatUserEmail.
+func ParseUserEmail(email string) (nodeName, username string, ok bool) {
+	if !strings.HasPrefix(email, "[") || !strings.HasSuffix(email, ")") {
+		return "", "", false
+	}
+	i := strings.LastIndex(email, "](")
+	if i < 0 {
+		return "", "", false
+	}
+	return email[i+2 : len(email)-1], email[1:i], true
+}
@@ -1,34 +1,125 @@
 package xray
 
 import (
+	"errors"
+	"fmt"
+	"github.com/InazumaV/Ratte-Core-Xray/limiter"
+	"github.com/InazumaV/Ratte-Core-Xray/rotate"
 	"github.com/goccy/go-json"
+	coreConf "github.com/xtls/xray-core/infra/conf"
 	"os"
+	"time"
 )
 
+// AutoLoadRawMessage is inline json or the path of a json, yaml or toml file,
+// or a directory of them merged in the order of their names.
+// It is loaded by XrayConfig.load with the paths relative to the data path.
 type AutoLoadRawMessage json.RawMessage
 
 func (j *AutoLoadRawMessage) UnmarshalJSON(data []byte) error {
+	*j = append((*j)[:0], data...)
+	return nil
+}
+
+// load reads the file or the directory if j is a path,
+// then expands the ${ENV} and $include in it.
+func (j *AutoLoadRawMessage) load(e *expander, merge mergeFunc) error {
+	data := []byte(*j)
 	var path string
 	err := json.Unmarshal(data, &path)
 	if err == nil {
+		if e.untrusted {
+			return fmt.Errorf("loading %s is not allowed in a config from the api", path)
+		}
+		path = e.resolve(path)
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			// the files are expanded while loading
+			data, err = e.loadDir(path, merge)
+			if err != nil {
+				return err
+			}
+			*j = data
+			return nil
+		}
 		f, err := os.ReadFile(path)
 		if err != nil {
 			return err
 		}
-		data = f
+		data, err = toJSON(path, f)
+		if err != nil {
+			return err
+		}
+	}
+	data, err = e.expand(data)
+	if err != nil {
+		return err
 	}
 	*j = data
 	return nil
 }
 
 type XrayConfig struct {
-	AssetPath string             `json:"AssetPath"`
-	Log       AutoLoadRawMessage `json:"Log"`
-	Dns       AutoLoadRawMessage `json:"Dns"`
-	Inbound   AutoLoadRawMessage `json:"Inbound"`
-	Outbound  AutoLoadRawMessage `json:"Outbound"`
-	Route     AutoLoadRawMessage `json:"Route"`
-	Policy    AutoLoadRawMessage `json:"Policy"`
+	AssetPath        string             `json:"AssetPath"`
+	Log              AutoLoadRawMessage `json:"Log"`
+	Dns              AutoLoadRawMessage `json:"Dns"`
+	Inbound          AutoLoadRawMessage `json:"Inbound"`
+	Outbound         AutoLoadRawMessage `json:"Outbound"`
+	Route            AutoLoadRawMessage `json:"Route"`
+	Policy           AutoLoadRawMessage `json:"Policy"`
+	KickDeletedUsers bool               `json:"KickDeletedUsers"`
+	Limiter          LimiterConfig      `json:"Limiter"`
+	// AccessLog writes a json line for every session when it ends, disabled if nil
+	AccessLog *rotate.Config `json:"AccessLog"`
+	// ControlSocket is the path of a unix socket serving the api for the cli,
+	// relative to the data path, disabled if empty
+	ControlSocket string `json:"ControlSocket"`
+	// Api turns on the grpc services of xray, disabled if nil
+	Api *ApiConfig `json:"Api"`
+	// Observatory probes the outbounds matched by its subjectSelector,
+	// balancers using leastPing, leastLoad or random with a fallbackTag skip
+	// the dead ones, and a node falls back to its FailoverOutbounds when its
+	// default outbound is dead. Only one of Observatory and BurstObservatory can be set.
+	Observatory      *coreConf.ObservatoryConfig      `json:"Observatory"`
+	BurstObservatory *coreConf.BurstObservatoryConfig `json:"BurstObservatory"`
+}
+
+type LimiterConfig struct {
+	// GlobalDeviceLimit enforces the device limit of a user against
+	// the ips the user is online with on every node
+	GlobalDeviceLimit bool          `json:"GlobalDeviceLimit"`
+	IpStore           IpStoreConfig `json:"IpStore"`
+	// Burst is the bytes a user may send at once above its speed limit,
+	// one second of the speed limit if zero
+	Burst int `json:"Burst"`
+	// Throttle is the fair-use policy applied to every node, disabled if nil
+	Throttle *limiter.ThrottleConfig `json:"Throttle"`
+}
+
+type IpStoreConfig struct {
+	// Type is "memory" or "redis", redis shares the online ips between servers
+	Type string `json:"Type"`
+	// TTL is the seconds an ip stays online after its last connection ends
+	TTL   int                 `json:"TTL"`
+	Redis limiter.RedisConfig `json:"Redis"`
+}
+
+func (c *IpStoreConfig) Build() (limiter.IpStore, error) {
+	ttl := time.Duration(c.TTL) * time.Second
+	switch c.Type {
+	case "", "memory":
+		return limiter.NewMemoryIpStore(ttl), nil
+	case "redis":
+		if c.Redis.Address == "" {
+			return nil, errors.New("redis address is empty")
+		}
+		return limiter.NewRedisIpStore(c.Redis, ttl), nil
+	default:
+		return nil, fmt.Errorf("unsupported ip store type: %s", c.Type)
+	}
 }
 
 const (
@@ -64,6 +155,35 @@ const (
 `
 )
 
+// load loads every AutoLoadRawMessage of the config with the paths relative to dataPath,
+// the error is ConfigErrors with the fields failed to load.
+// An untrusted config is loaded without reading files or the environment.
+func (c *XrayConfig) load(dataPath string, untrusted bool) error {
+	e := &expander{dataPath: dataPath, untrusted: untrusted}
+	var errs ConfigErrors
+	for _, f := range []struct {
+		name  string
+		j     *AutoLoadRawMessage
+		merge mergeFunc
+	}{
+		{"Log", &c.Log, mergeFiles},
+		{"Dns", &c.Dns, mergeFiles},
+		{"Inbound", &c.Inbound, mergeTagged("inbound")},
+		{"Outbound", &c.Outbound, mergeTagged("outbound")},
+		// the rules are appended in the order of the files
+		{"Route", &c.Route, mergeFiles},
+		{"Policy", &c.Policy, mergeFiles},
+	} {
+		if err := f.j.load(e, f.merge); err != nil {
+			errs.add(f.name, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
 func NewXrayConfig() *XrayConfig {
 	return &XrayConfig{
 		AssetPath: "",
@@ -73,5 +193,7 @@ func NewXrayConfig() *XrayConfig {
 		Outbound:  AutoLoadRawMessage(defOutbound),
 		Route:     AutoLoadRawMessage(defRoute),
 		Policy:    AutoLoadRawMessage(defPolicy),
+
+		KickDeletedUsers: true,
 	}
 }
@@ -0,0 +1,325 @@
+package xray
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/goccy/go-json"
+)
+
+// includeKey is the key of an object replaced by the files it includes,
+// its value is a path or a list of paths of files or directories.
+const includeKey = "$include"
+
+// maxIncludeDepth limits the nesting of includes
+const maxIncludeDepth = 16
+
+// envRe matches ${ENV}, and $${ENV} escaping it
+var envRe = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expander expands the environment variables and the includes of a config,
+// the paths of includes are relative to dataPath.
+type expander struct {
+	dataPath string
+	// untrusted leaves ${ENV} as it is and rejects $include and the paths of files,
+	// a config from the api must not read the environment or the files of the host
+	untrusted bool
+	// including is the stack of the files being included
+	including []string
+}
+
+// expand returns the config with every ${ENV} in strings replaced by the variable,
+// and every object with $include replaced by the merged content of the included files
+// and the keys beside $include.
+func (e *expander) expand(data []byte) ([]byte, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return data, nil
+	}
+	v, err := decodeAny(data)
+	if err != nil {
+		return nil, err
+	}
+	v, err = e.walk(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+func decodeAny(data []byte) (any, error) {
+	var v any
+	d := json.NewDecoder(bytes.NewReader(data))
+	// keep the numbers as they are, such as the ids of ports
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (e *expander) walk(v any) (any, error) {
+	switch v := v.(type) {
+	case string:
+		if e.untrusted {
+			return v, nil
+		}
+		return expandEnv(v), nil
+	case []any:
+		r := make([]any, 0, len(v))
+		for _, item := range v {
+			m, ok := item.(map[string]any)
+			only := ok && len(m) == 1 && m[includeKey] != nil
+			n, err := e.walk(item)
+			if err != nil {
+				return nil, err
+			}
+			// the items of an included list are spliced into the list
+			if l, ok := n.([]any); ok && only {
+				r = append(r, l...)
+				continue
+			}
+			r = append(r, n)
+		}
+		return r, nil
+	case map[string]any:
+		var base any
+		if inc, ok := v[includeKey]; ok {
+			if e.untrusted {
+				return nil, fmt.Errorf("%s is not allowed in a config from the api", includeKey)
+			}
+			var err error
+			base, err = e.include(inc)
+			if err != nil {
+				return nil, err
+			}
+			delete(v, includeKey)
+			if len(v) == 0 {
+				return base, nil
+			}
+		}
+		for k, item := range v {
+			n, err := e.walk(item)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = n
+		}
+		if base == nil {
+			return v, nil
+		}
+		// the keys beside $include are merged into the included ones,
+		// so the lists are appended to and the other values are replaced
+		return mergeConfig(base, v)
+	default:
+		return v, nil
+	}
+}
+
+// expandEnv replaces every ${ENV} in s with the variable, and $${ENV} with ${ENV}.
+// An unset variable is left as it is, so a literal ${ is kept in the config.
+func expandEnv(s string) string {
+	return envRe.ReplaceAllStringFunc(s, func(m string) string {
+		if strings.HasPrefix(m, "$$") {
+			return m[1:]
+		}
+		if v, ok := os.LookupEnv(m[2 : len(m)-1]); ok {
+			return v
+		}
+		return m
+	})
+}
+
+// include loads and merges the files of the paths in order,
+// the files of a directory are loaded in the order of their names.
+func (e *expander) include(v any) (any, error) {
+	var paths []string
+	switch v := v.(type) {
+	case string:
+		paths = []string{v}
+	case []any:
+		for _, p := range v {
+			s, ok := p.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must be a path or a list of paths", includeKey)
+			}
+			paths = append(paths, s)
+		}
+	default:
+		return nil, fmt.Errorf("%s must be a path or a list of paths", includeKey)
+	}
+	var r any
+	for _, p := range paths {
+		files, err := e.listFiles(expandEnv(p))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			n, err := e.includeFile(f)
+			if err != nil {
+				return nil, err
+			}
+			if r, err = mergeConfig(r, n); err != nil {
+				return nil, fmt.Errorf("merge %s error: %w", f, err)
+			}
+		}
+	}
+	return r, nil
+}
+
+func (e *expander) resolve(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(e.dataPath, p)
+}
+
+// listFiles returns the file of the path,
+// or the files in it sorted by name if it is a directory.
+func (e *expander) listFiles(p string) ([]string, error) {
+	p = e.resolve(p)
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{p}, nil
+	}
+	es, err := os.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(es))
+	for _, de := range es {
+		if de.IsDir() || strings.HasPrefix(de.Name(), ".") {
+			continue
+		}
+		// skip the others such as a readme beside the fragments
+		switch strings.ToLower(filepath.Ext(de.Name())) {
+		case ".json", ".yaml", ".yml", ".toml":
+		default:
+			continue
+		}
+		files = append(files, filepath.Join(p, de.Name()))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (e *expander) includeFile(f string) (any, error) {
+	for _, inc := range e.including {
+		if inc == f {
+			return nil, fmt.Errorf("include cycle: %s -> %s", strings.Join(e.including, " -> "), f)
+		}
+	}
+	if len(e.including) >= maxIncludeDepth {
+		return nil, fmt.Errorf("include %s: too deep", f)
+	}
+	data, err := os.ReadFile(f)
+	if err != nil {
+		return nil, err
+	}
+	data, err = toJSON(f, data)
+	if err != nil {
+		return nil, err
+	}
+	v, err := decodeAny(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f, err)
+	}
+	e.including = append(e.including, f)
+	defer func() { e.including = e.including[:len(e.including)-1] }()
+	return e.walk(v)
+}
+
+// mergeFunc merges v loaded from the file into acc
+type mergeFunc func(acc any, file string, v any) (any, error)
+
+// loadDir loads the files in the directory in the order of their names and merges them.
+func (e *expander) loadDir(dir string, merge mergeFunc) ([]byte, error) {
+	files, err := e.listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	var r any
+	for _, f := range files {
+		v, err := e.includeFile(f)
+		if err != nil {
+			return nil, err
+		}
+		if r, err = merge(r, f, v); err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+	}
+	if r == nil {
+		return nil, fmt.Errorf("no config file in %s", dir)
+	}
+	return json.Marshal(r)
+}
+
+func mergeFiles(acc any, _ string, v any) (any, error) {
+	return mergeConfig(acc, v)
+}
+
+// mergeTagged returns a mergeFunc concatenating the lists of inbounds or outbounds,
+// the tags of them must be unique.
+func mergeTagged(kind string) mergeFunc {
+	tags := make(map[string]string)
+	return func(acc any, file string, v any) (any, error) {
+		l, ok := v.([]any)
+		if !ok {
+			if _, ok = v.(map[string]any); !ok {
+				return nil, fmt.Errorf("%s config must be a list or an object", kind)
+			}
+			l = []any{v}
+		}
+		for _, item := range l {
+			m, _ := item.(map[string]any)
+			tag, _ := m["tag"].(string)
+			if tag == "" {
+				continue
+			}
+			if first, ok := tags[tag]; ok {
+				return nil, fmt.Errorf("duplicate %s tag %s, first defined in %s", kind, tag, first)
+			}
+			tags[tag] = file
+		}
+		return mergeConfig(acc, l)
+	}
+}
+
+// mergeConfig merges b into a, the objects are merged by keys,
+// the lists are concatenated and others are replaced by b.
+func mergeConfig(a, b any) (any, error) {
+	if a == nil {
+		return b, nil
+	}
+	switch av := a.(type) {
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok {
+			return nil, errors.New("can not merge a non-object into an object")
+		}
+		for k, item := range bv {
+			n, err := mergeConfig(av[k], item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			av[k] = n
+		}
+		return av, nil
+	case []any:
+		bv, ok := b.([]any)
+		if !ok {
+			return nil, errors.New("can not merge a non-list into a list")
+		}
+		return append(av, bv...), nil
+	default:
+		return b, nil
+	}
+}
@@ -0,0 +1,153 @@
+package xray
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, data := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestXrayConfig_load(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TEST_OUT_PASS", "secret")
+	writeFiles(t, dir, map[string]string{
+		"outbound.json":          `[{"$include": "outbounds"}, {"tag": "last", "protocol": "freedom"}]`,
+		"outbounds/10-a.json":    `[{"tag": "a", "protocol": "freedom"}]`,
+		"outbounds/20-b.yaml":    "- tag: b\n  protocol: socks\n  settings:\n    servers:\n      - address: 1.1.1.1\n        port: 1080\n        users:\n          - user: u\n            pass: ${TEST_OUT_PASS}\n",
+		"outbounds/.hidden.json": `[{"tag": "hidden"}]`,
+		"policy.json":            `{"handshake": 1}`,
+	})
+	c, err := decodeXrayConfig(dir, []byte(`{
+	"Outbound": "outbound.json",
+	"Policy": {"$include": "policy.json", "connIdle": 10}
+}`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(c.Outbound)
+	for _, want := range []string{`"tag":"a"`, `"tag":"b"`, `"tag":"last"`, `"pass":"secret"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("outbound %s does not contain %s", out, want)
+		}
+	}
+	if strings.Contains(out, "hidden") || strings.Index(out, `"tag":"a"`) > strings.Index(out, `"tag":"b"`) {
+		t.Errorf("outbound = %s", out)
+	}
+	if p := string(c.Policy); !strings.Contains(p, `"handshake":1`) || !strings.Contains(p, `"connIdle":10`) {
+		t.Errorf("policy = %s", p)
+	}
+	if _, _, err = buildCoreConfig(c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestXrayConfig_load_Error(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.json": `{"$include": "b.json"}`,
+		"b.json": `{"$include": "a.json"}`,
+	})
+	_, err := decodeXrayConfig(dir, []byte(`{
+	"Route": {"$include": "a.json"},
+	"Dns": {"$include": "missing.json"}
+}`), false)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	for _, want := range []string{"Route: include cycle", "Dns: stat " + filepath.Join(dir, "missing.json")} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+}
+
+func TestXrayConfig_load_Env(t *testing.T) {
+	t.Setenv("TEST_ENV_SET", "a")
+	c, err := decodeXrayConfig("./", []byte(`{
+	"Dns": {"hosts": {"x": "${TEST_ENV_SET}", "y": "$${TEST_ENV_SET}", "z": "${TEST_NOT_SET_ENV}"}}
+}`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(c.Dns), `{"hosts":{"x":"a","y":"${TEST_ENV_SET}","z":"${TEST_NOT_SET_ENV}"}}`; got != want {
+		t.Errorf("dns = %s, want %s", got, want)
+	}
+}
+
+func TestXrayConfig_load_Untrusted(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TEST_ENV_SET", "a")
+	writeFiles(t, dir, map[string]string{
+		"route.json": `{"rules": []}`,
+	})
+	c, err := decodeXrayConfig(dir, []byte(`{"Dns": {"hosts": {"x": "${TEST_ENV_SET}"}}}`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(c.Dns), `{"hosts":{"x":"${TEST_ENV_SET}"}}`; got != want {
+		t.Errorf("dns = %s, want %s", got, want)
+	}
+	_, err = decodeXrayConfig(dir, []byte(`{
+	"Route": "route.json",
+	"Policy": {"$include": "route.json"}
+}`), true)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	for _, want := range []string{"Route: loading route.json is not allowed", "Policy: $include is not allowed"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+}
+
+func TestXrayConfig_load_Dir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"outbound.d/10-team-a.json": `[{"tag": "a", "protocol": "freedom"}]`,
+		"outbound.d/20-team-b.yaml": "tag: b\nprotocol: blackhole\n",
+		"outbound.d/README.md":      "# outbounds of the teams",
+		"route.d/10-a.json":         `{"domainStrategy": "AsIs", "rules": [{"type": "field", "ruleTag": "r1", "outboundTag": "a", "port": 1}]}`,
+		"route.d/20-b.toml":         "[[rules]]\ntype = \"field\"\nruleTag = \"r2\"\noutboundTag = \"b\"\nport = 2\n",
+	})
+	c, err := decodeXrayConfig(dir, []byte(`{"Outbound": "outbound.d", "Route": "route.d"}`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(c.Outbound)
+	if !strings.Contains(out, `"tag":"a"`) || strings.Index(out, `"tag":"a"`) > strings.Index(out, `"tag":"b"`) {
+		t.Errorf("outbound = %s", out)
+	}
+	route := string(c.Route)
+	if !strings.Contains(route, `"r1"`) || strings.Index(route, `"r1"`) > strings.Index(route, `"r2"`) {
+		t.Errorf("route = %s", route)
+	}
+	if _, _, err = buildCoreConfig(c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestXrayConfig_load_DirDuplicateTag(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"outbound.d/a.json": `[{"tag": "x", "protocol": "freedom"}]`,
+		"outbound.d/b.json": `[{"tag": "x", "protocol": "freedom"}]`,
+	})
+	_, err := decodeXrayConfig(dir, []byte(`{"Outbound": "outbound.d"}`), false)
+	if err == nil || !strings.Contains(err.Error(), "duplicate outbound tag x") ||
+		!strings.Contains(err.Error(), "b.json") {
+		t.Errorf("error = %v", err)
+	}
+}
@@ -0,0 +1,403 @@
+package xray
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pelletier/go-toml/v2/unstable"
+	"gopkg.in/yaml.v3"
+)
+
+// Formats of config files
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+var tomlTableRe = regexp.MustCompile(`^\[\[?[\w.-]+\]\]?$`)
+var tomlLineRe = regexp.MustCompile(`^\s*(\[[^\[\]]+\]|\[\[[^\[\]]+\]\]|[\w.-]+\s*=)`)
+
+// detectFormat returns the format of a config by the extension of name,
+// or by its content if the extension is unknown.
+func detectFormat(name string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return FormatJSON
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] == '{' || data[0] == '"' {
+		return FormatJSON
+	}
+	if data[0] == '[' {
+		// a json array or a toml table
+		first, _, _ := bytes.Cut(data, []byte{'\n'})
+		if tomlTableRe.Match(bytes.TrimSpace(first)) {
+			return FormatTOML
+		}
+		return FormatJSON
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if tomlLineRe.MatchString(line) {
+			return FormatTOML
+		}
+		break
+	}
+	return FormatYAML
+}
+
+// toJSON converts a config in any format to json, name is the file of the
+// config used in errors, empty for an inline config.
+// Errors carry the location of the problem as name:line:column.
+func toJSON(name string, data []byte) ([]byte, error) {
+	if name == "" {
+		name = "config"
+	}
+	switch detectFormat(name, data) {
+	case FormatYAML:
+		var v any
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return nil, yamlError(name, err)
+		}
+		v, err := normalizeYAML(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return json.Marshal(v)
+	case FormatTOML:
+		var v map[string]any
+		if err := toml.Unmarshal(data, &v); err != nil {
+			return nil, tomlError(name, err)
+		}
+		return json.Marshal(v)
+	default:
+		if err := checkJSON(data); err != nil {
+			return nil, jsonError(name, data, err)
+		}
+		return data, nil
+	}
+}
+
+// decodeConfig decodes a config in any format into v, name is the file of the
+// config used in errors, empty for an inline config.
+// Errors of both parsing and decoding carry the location as name:line:column.
+func decodeConfig(name string, data []byte, v any) error {
+	if name == "" {
+		name = "config"
+	}
+	j, err := toJSON(name, data)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(j, v); err != nil {
+		return decodeError(name, data, j, err)
+	}
+	return nil
+}
+
+// decodeError locates the error of decoding j, the json converted from data, in data.
+func decodeError(name string, data, j []byte, err error) error {
+	var te *json.UnmarshalTypeError
+	if !errors.As(err, &te) {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	var line, col int
+	switch detectFormat(name, data) {
+	case FormatYAML:
+		line, col = yamlPos(data, jsonPath(j, te.Offset))
+	case FormatTOML:
+		line, col = tomlPos(data, jsonPath(j, te.Offset))
+	default:
+		line, col = lineCol(data, te.Offset)
+	}
+	if line == 0 {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return fmt.Errorf("%s:%d:%d: %w", name, line, col, err)
+}
+
+// jsonPath returns the keys and indexes leading to the value starting at the offset of data.
+func jsonPath(data []byte, offset int64) []string {
+	type frame struct {
+		array bool
+		index int
+		key   string
+	}
+	var stack []*frame
+	var path []string
+	// done moves the parent to the next key or element after a value
+	done := func() {
+		if len(stack) > 0 {
+			if f := stack[len(stack)-1]; f.array {
+				f.index++
+			} else {
+				f.key = ""
+			}
+		}
+	}
+	dec := stdjson.NewDecoder(bytes.NewReader(data))
+	for {
+		start := dec.InputOffset()
+		if start > offset {
+			return path
+		}
+		tok, err := dec.Token()
+		if err != nil {
+			return path
+		}
+		if len(stack) > 0 {
+			if f := stack[len(stack)-1]; !f.array && f.key == "" {
+				if k, ok := tok.(string); ok {
+					f.key = k
+					continue
+				}
+			}
+		}
+		if d, ok := tok.(stdjson.Delim); ok && (d == '}' || d == ']') {
+			stack = stack[:len(stack)-1]
+			done()
+			continue
+		}
+		path = path[:0]
+		for _, f := range stack {
+			if f.array {
+				path = append(path, strconv.Itoa(f.index))
+			} else {
+				path = append(path, f.key)
+			}
+		}
+		switch tok {
+		case stdjson.Delim('{'):
+			stack = append(stack, &frame{})
+		case stdjson.Delim('['):
+			stack = append(stack, &frame{array: true})
+		default:
+			done()
+		}
+	}
+}
+
+// yamlPos returns the line and column of the value at the path in the yaml data,
+// or of the deepest value found on the path.
+func yamlPos(data []byte, path []string) (line, col int) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 {
+		return 0, 0
+	}
+	n := doc.Content[0]
+	for _, k := range path {
+		for n.Kind == yaml.AliasNode && n.Alias != nil {
+			n = n.Alias
+		}
+		var next *yaml.Node
+		switch n.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				if n.Content[i].Value == k {
+					next = n.Content[i+1]
+					break
+				}
+			}
+		case yaml.SequenceNode:
+			if i, err := strconv.Atoi(k); err == nil && i < len(n.Content) {
+				next = n.Content[i]
+			}
+		}
+		if next == nil {
+			break
+		}
+		n = next
+	}
+	return n.Line, n.Column
+}
+
+// tomlPos returns the line and column of the value at the path in the toml data,
+// or of the deepest value found on the path.
+func tomlPos(data []byte, path []string) (line, col int) {
+	want := strings.Join(path, "\x00")
+	best, depth := -1, -1
+	record := func(p []string, r unstable.Range) {
+		if len(p) < depth || r.Length == 0 {
+			return
+		}
+		k := strings.Join(p, "\x00")
+		if k == want || strings.HasPrefix(want, k+"\x00") {
+			best, depth = int(r.Offset), len(p)
+		}
+	}
+	var walk, keyValue func(p []string, n *unstable.Node)
+	walk = func(p []string, n *unstable.Node) {
+		record(p, n.Raw)
+		switch n.Kind {
+		case unstable.Array:
+			i := 0
+			for it := n.Children(); it.Next(); i++ {
+				walk(append(p[:len(p):len(p)], strconv.Itoa(i)), it.Node())
+			}
+		case unstable.InlineTable:
+			for it := n.Children(); it.Next(); {
+				keyValue(p, it.Node())
+			}
+		}
+	}
+	keyValue = func(table []string, n *unstable.Node) {
+		p := table[:len(table):len(table)]
+		for it := n.Key(); it.Next(); {
+			p = append(p, string(it.Node().Data))
+			record(p, it.Node().Raw)
+		}
+		walk(p, n.Value())
+	}
+	// the current index of every array of tables by its key
+	arrays := make(map[string]int)
+	var table []string
+	var parser unstable.Parser
+	parser.Reset(data)
+	for parser.NextExpression() {
+		e := parser.Expression()
+		switch e.Kind {
+		case unstable.KeyValue:
+			keyValue(table, e)
+		case unstable.Table, unstable.ArrayTable:
+			var keys []string
+			for it := e.Key(); it.Next(); {
+				keys = append(keys, string(it.Node().Data))
+			}
+			raw := strings.Join(keys, ".")
+			if e.Kind == unstable.ArrayTable {
+				if i, ok := arrays[raw]; ok {
+					arrays[raw] = i + 1
+				} else {
+					arrays[raw] = 0
+				}
+				// the tables nested in the last one start over
+				for k := range arrays {
+					if strings.HasPrefix(k, raw+".") {
+						delete(arrays, k)
+					}
+				}
+			}
+			table = nil
+			for i, k := range keys {
+				table = append(table, k)
+				if idx, ok := arrays[strings.Join(keys[:i+1], ".")]; ok {
+					table = append(table, strconv.Itoa(idx))
+				}
+			}
+			if it := e.Key(); it.Next() {
+				record(table, it.Node().Raw)
+			}
+		}
+	}
+	if best < 0 {
+		return 0, 0
+	}
+	return lineCol(data, int64(best))
+}
+
+func checkJSON(data []byte) error {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	var v any
+	return stdjson.Unmarshal(data, &v)
+}
+
+// lineCol returns the line and column of the offset in data, both start from 1.
+func lineCol(data []byte, offset int64) (line, col int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line = bytes.Count(before, []byte{'\n'}) + 1
+	col = int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
+
+func jsonError(name string, data []byte, err error) error {
+	var se *stdjson.SyntaxError
+	if errors.As(err, &se) {
+		line, col := lineCol(data, se.Offset)
+		return fmt.Errorf("%s:%d:%d: %w", name, line, col, err)
+	}
+	var te *stdjson.UnmarshalTypeError
+	if errors.As(err, &te) {
+		line, col := lineCol(data, te.Offset)
+		return fmt.Errorf("%s:%d:%d: %w", name, line, col, err)
+	}
+	return fmt.Errorf("%s: %w", name, err)
+}
+
+var yamlLineRe = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+func yamlError(name string, err error) error {
+	var te *yaml.TypeError
+	if errors.As(err, &te) {
+		return fmt.Errorf("%s: %s", name, strings.Join(te.Errors, "; "))
+	}
+	if m := yamlLineRe.FindStringSubmatch(err.Error()); m != nil {
+		return fmt.Errorf("%s:%s: %s", name, m[1], m[2])
+	}
+	return fmt.Errorf("%s: %w", name, err)
+}
+
+func tomlError(name string, err error) error {
+	var de *toml.DecodeError
+	if errors.As(err, &de) {
+		line, col := de.Position()
+		return fmt.Errorf("%s:%d:%d: %w", name, line, col, err)
+	}
+	return fmt.Errorf("%s: %w", name, err)
+}
+
+// normalizeYAML converts the maps with keys of any type decoded by yaml to maps of string keys.
+func normalizeYAML(v any) (any, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			n, err := normalizeYAML(e)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = n
+		}
+		return v, nil
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			n, err := normalizeYAML(e)
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(k)] = n
+		}
+		return m, nil
+	case []any:
+		for i, e := range v {
+			n, err := normalizeYAML(e)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = n
+		}
+		return v, nil
+	default:
+		return v, nil
+	}
+}
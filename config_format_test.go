@@ -0,0 +1,114 @@
+package xray
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+)
+
+func TestDetectFormat(t *testing.T) {
+	cases := []struct {
+		name, data, want string
+	}{
+		{"a.yml", "{}", FormatYAML},
+		{"a.TOML", "", FormatTOML},
+		{"a.json", "a: 1", FormatJSON},
+		{"", ` {"a": 1}`, FormatJSON},
+		{"", "# c\n[Limiter]\nBurst = 1", FormatTOML},
+		{"", "Burst = 1", FormatTOML},
+		{"", "[1, 2]", FormatJSON},
+		{"", "Limiter:\n  Burst: 1", FormatYAML},
+	}
+	for _, c := range cases {
+		if got := detectFormat(c.name, []byte(c.data)); got != c.want {
+			t.Errorf("detectFormat(%q, %q) = %s, want %s", c.name, c.data, got, c.want)
+		}
+	}
+}
+
+func TestToJSON(t *testing.T) {
+	want := map[string]any{"Limiter": map[string]any{"Burst": float64(1)}}
+	for _, data := range []string{
+		`{"Limiter": {"Burst": 1}}`,
+		"Limiter:\n  Burst: 1\n",
+		"[Limiter]\nBurst = 1\n",
+	} {
+		j, err := toJSON("", []byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got map[string]any
+		if err = json.Unmarshal(j, &got); err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := json.Marshal(got); string(b) != `{"Limiter":{"Burst":1}}` {
+			t.Errorf("toJSON(%q) = %s, want %v", data, b, want)
+		}
+	}
+}
+
+func TestToJSON_Error(t *testing.T) {
+	cases := []struct {
+		name, data, want string
+	}{
+		{"a.json", "{\n  \"a\": 1,\n  \"b\"\n}", "a.json:4:"},
+		{"a.yaml", "a: 1\nb: [\n", "a.yaml:"},
+		{"a.yaml", "a: 1\n  b: 2\n", "a.yaml:2:"},
+		{"a.toml", "a = 1\nb = @\n", "a.toml:2:5:"},
+	}
+	for _, c := range cases {
+		_, err := toJSON(c.name, []byte(c.data))
+		if err == nil || !strings.HasPrefix(err.Error(), c.want) {
+			t.Errorf("toJSON(%s) error = %v, want prefix %s", c.name, err, c.want)
+		}
+	}
+}
+
+func TestDecodeConfig_TypeError(t *testing.T) {
+	type node struct {
+		Name string `json:"Name"`
+		Port int    `json:"Port"`
+	}
+	type nodes struct {
+		Nodes []node `json:"Nodes"`
+	}
+	cases := []struct {
+		name, data, want string
+	}{
+		{"a.json", "{\n  \"Nodes\": [\n    {\"Port\": \"abc\"}\n  ]\n}", "a.json:3:14:"},
+		{"a.yaml", "Nodes:\n  - Name: n1\n    Port: 1\n  - Name: n2\n    Port: abc\n", "a.yaml:5:11:"},
+		{"a.yaml", "n: &n\n  Port: abc\nNodes:\n  - *n\n", "a.yaml:2:9:"},
+		{"a.toml", "[[Nodes]]\nName = \"n1\"\n\n[[Nodes]]\nName = \"n2\"\nPort = \"abc\"\n", "a.toml:6:8:"},
+		{"a.toml", "Nodes = [{Port = 1}, {Port = \"abc\"}]\n", "a.toml:1:30:"},
+		{"a.toml", "[[Nodes]]\nName = 1\n", "a.toml:2:8:"},
+	}
+	for _, c := range cases {
+		var v nodes
+		err := decodeConfig(c.name, []byte(c.data), &v)
+		if err == nil || !strings.HasPrefix(err.Error(), c.want) {
+			t.Errorf("decodeConfig(%s) error = %v, want prefix %s", c.data, err, c.want)
+		}
+	}
+}
+
+func TestAutoLoadRawMessage_Yaml(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "route.yaml")
+	if err := os.WriteFile(path, []byte("domainStrategy: AsIs\nrules: []\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p, _ := json.Marshal(path)
+	c, err := decodeXrayConfig("./", []byte(`{"Route": `+string(p)+`}`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var route map[string]any
+	if err = json.Unmarshal(c.Route, &route); err != nil {
+		t.Fatal(err)
+	}
+	if route["domainStrategy"] != "AsIs" {
+		t.Errorf("route = %v", route)
+	}
+}
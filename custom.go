@@ -0,0 +1,260 @@
+package xray
+
+import (
+	"encoding/gob"
+	"fmt"
+	"github.com/InazumaV/Ratte-Core-Xray/common"
+	"github.com/InazumaV/Ratte-Core-Xray/dispatcher"
+	"github.com/InazumaV/Ratte-Core-Xray/limiter"
+	"github.com/InazumaV/Ratte-Interface/common/errors"
+	"github.com/InazumaV/Ratte-Interface/core"
+	mapS "github.com/mitchellh/mapstructure"
+)
+
+const (
+	MethodGetConnections    = "GetConnections"
+	MethodKickUser          = "KickUser"
+	MethodDelUsers          = "DelUsers"
+	MethodGetRejects        = "GetRejects"
+	MethodGetThrottled      = "GetThrottled"
+	MethodBan               = "Ban"
+	MethodUnban             = "Unban"
+	MethodGetBans           = "GetBans"
+	MethodSetSourceFilter   = "SetSourceFilter"
+	MethodGetSourceFilter   = "GetSourceFilter"
+	MethodGetRegionRejects  = "GetRegionRejects"
+	MethodSetNodeSpeedLimit = "SetNodeSpeedLimit"
+	MethodSetQuota          = "SetQuota"
+	MethodGetQuotas         = "GetQuotas"
+	MethodGetEvents         = "GetEvents"
+	MethodValidate          = "Validate"
+	MethodAddInbound        = "AddInbound"
+	MethodRemoveInbound     = "RemoveInbound"
+	MethodAddOutbound       = "AddOutbound"
+	MethodRemoveOutbound    = "RemoveOutbound"
+	MethodListNodes         = "ListNodes"
+	MethodListUsers         = "ListUsers"
+	MethodGetOnline         = "GetOnline"
+	MethodReload            = "Reload"
+	MethodGetOutboundHealth = "GetOutboundHealth"
+)
+
+func init() {
+	// reply values travel through net/rpc as interfaces
+	gob.Register([]dispatcher.ConnInfo{})
+	gob.Register(map[string]int64{})
+	gob.Register([]limiter.ThrottleStatus{})
+	gob.Register([]limiter.Ban{})
+	gob.Register(limiter.SourceFilter{})
+	gob.Register([]limiter.QuotaStatus{})
+	gob.Register(&GetEventsReply{})
+	gob.Register(ConfigErrors{})
+	gob.Register([]NodeStatus{})
+	gob.Register([]UserStatus{})
+	gob.Register([]OnlineUser{})
+	gob.Register([]dispatcher.OutboundHealth{})
+}
+
+func decodeArgs(args any, p any) error {
+	if args == nil {
+		return nil
+	}
+	err := mapS.Decode(args, p)
+	if err != nil {
+		return fmt.Errorf("decode args error: %s", err)
+	}
+	return nil
+}
+
+type GetConnectionsParams struct {
+	NodeName string `mapstructure:"NodeName"`
+	Username string `mapstructure:"Username"`
+}
+
+func (c *Xray) GetConnections(p *GetConnectionsParams) []dispatcher.ConnInfo {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	var email string
+	if p.Username != "" {
+		email = common.FormatUserEmail(p.NodeName, p.Username)
+	}
+	return c.dispatcher.ListConns(p.NodeName, email)
+}
+
+type KickUserParams struct {
+	NodeName string `mapstructure:"NodeName"`
+	Username string `mapstructure:"Username"`
+}
+
+type DelUsersParams struct {
+	NodeName string   `mapstructure:"NodeName"`
+	Users    []string `mapstructure:"Users"`
+	Kick     bool     `mapstructure:"Kick"`
+}
+
+func (c *Xray) CustomMethod(method string, args any, reply *any) (err error) {
+	defer func() {
+		if err != nil {
+			err = errors.NewStringFromErr(err)
+		}
+	}()
+	switch method {
+	case MethodGetConnections:
+		p := &GetConnectionsParams{}
+		if err = decodeArgs(args, p); err != nil {
+			return err
+		}
+		*reply = c.GetConnections(p)
+	case MethodKickUser:
+		p := &KickUserParams{}
+		if err = decodeArgs(args, p); err != nil {
+			return err
+		}
+		*reply, err = c.KickUser(p.NodeName, p.Username)
+		return err
+	case MethodDelUsers:
+		p := &DelUsersParams{}
+		if err = decodeArgs(args, p); err != nil {
+			return err
+		}
+		c.access.RLock()
+		defer c.access.RUnlock()
+		return c.delUsers(&core.DelUsersParams{
+			NodeName: p.NodeName,
+			Users:    p.Users,
+		}, p.Kick)
+	case MethodGetRejects:
+		p := &GetRejectsParams{}
+		if err = decodeArgs(args, p); err != nil {
+			return err
+		}
+		*reply, err = c.GetRejects(p)
+		return err
+	case MethodGetRegionRejects:
+		p := &GetRegionRejectsParams{}
+		if err = decodeArgs(args, p); err != nil {
+			return err
+		}
+		*reply, err = c.GetRegionRejects(p)
+		return err
+	case MethodGetThrottled:
+		p := &GetThrottledParams{}
+		if err = decodeArgs(args, p); err != nil {
+			return err
+		}
+		*reply, err = c.GetThrottled(p)
+		return err
+	case MethodBan:
+		p := &BanParams{}
+		if err = decodeArgs(args, p); err != nil {
+			return err
+		}
+		return c.Ban(p)
+	case MethodUnban:
+		p := &UnbanParams{}
+		if err = decodeArgs(args, p); err != nil {
+			return err
+		}
+		*reply, err = c.Unban(p)
+		return err
+	case MethodGetBans:
+		p := &GetBansParams{}
+		if err = decodeArgs(args, p); err != nil {
+			return err
+		}
+		*reply, err = c.GetBans(p)
+		return err
+	case MethodSetSourceFilter:
+		p := &SetSourceFilterParams{}
+		if err = decodeArgs(args, p); err != nil {
+			return err
+		}
+		return c.SetSourceFilter(p)
+	case MethodGetSourceFilter:
+		p := &GetSourceFilterParams{}
+		if err = decodeArgs(args, p); err != nil {
+			return err
+		}
+		*reply, err = c.GetSourceFilter(p)
+		return err
+	case MethodSetNodeSpeedLimit:
+		p := &SetNodeSpeedLimitParams{}
+		if err = decodeArgs(args, p); err != nil {
+			return err
+		}
+		return c.SetNodeSpeedLimit(p)
+	case MethodSetQuota:
+		p := &SetQuotaParams{}
+		if err = decodeArgs(args, p); err != nil {
+			return err
+		}
+		return c.SetQuota(p)
+	case MethodGetQuotas:
+		p := &GetQuotasParams{}
+		if err = decodeArgs(args, p); err != nil {
+			return err
+		}
+		*reply, err = c.GetQuotas(p)
+		return err
+	case MethodGetEvents:
+		p := &GetEventsParams{}
+		if err = decodeArgs(args, p); err != nil {
+			return err
+		}
+		*reply = c.GetEvents(p)
+	case MethodValidate:
+		p := &ValidateParams{}
+		if err = decodeArgs(args, p); err != nil {
+			return err
+		}
+		*reply, err = c.Validate(p)
+		return err
+	case MethodAddInbound:
+		p := &AddInboundParams{}
+		if err = decodeArgs(args, p); err != nil {
+			return err
+		}
+		return c.AddInbound(p)
+	case MethodRemoveInbound:
+		p := &RemoveInboundParams{}
+		if err = decodeArgs(args, p); err != nil {
+			return err
+		}
+		return c.RemoveInbound(p)
+	case MethodAddOutbound:
+		p := &AddOutboundParams{}
+		if err = decodeArgs(args, p); err != nil {
+			return err
+		}
+		return c.AddOutbound(p)
+	case MethodRemoveOutbound:
+		p := &RemoveOutboundParams{}
+		if err = decodeArgs(args, p); err != nil {
+			return err
+		}
+		return c.RemoveOutbound(p)
+	case MethodListNodes:
+		*reply = c.ListNodes()
+	case MethodListUsers:
+		p := &ListUsersParams{}
+		if err = decodeArgs(args, p); err != nil {
+			return err
+		}
+		*reply, err = c.ListUsers(p)
+		return err
+	case MethodGetOnline:
+		p := &GetOnlineParams{}
+		if err = decodeArgs(args, p); err != nil {
+			return err
+		}
+		*reply, err = c.GetOnline(p)
+		return err
+	case MethodReload:
+		return c.Reload()
+	case MethodGetOutboundHealth:
+		*reply = c.GetOutboundHealth()
+	default:
+		return fmt.Errorf("unsupported method: %s", method)
+	}
+	return nil
+}
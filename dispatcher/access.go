@@ -0,0 +1,69 @@
+package dispatcher
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	ic "github.com/InazumaV/Ratte-Core-Xray/common"
+	"github.com/goccy/go-json"
+)
+
+// AccessRecord is a line of the json access log, written when a session ends.
+type AccessRecord struct {
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Node        string    `json:"node"`
+	User        string    `json:"user"`
+	Email       string    `json:"email"`
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Protocol    string    `json:"protocol,omitempty"`
+	Outbound    string    `json:"outbound,omitempty"`
+	Up          int64     `json:"up"`
+	Down        int64     `json:"down"`
+}
+
+type accessLog struct {
+	access sync.Mutex
+	w      io.Writer
+}
+
+// SetAccessLog sets the writer the json access log is written to, nil disables it.
+func (d *DefaultDispatcher) SetAccessLog(w io.Writer) {
+	if w == nil {
+		d.accessLog.Store(nil)
+		return
+	}
+	d.accessLog.Store(&accessLog{w: w})
+}
+
+func (d *DefaultDispatcher) logAccess(info ConnInfo, end time.Time) {
+	l := d.accessLog.Load()
+	if l == nil {
+		return
+	}
+	r := AccessRecord{
+		Start:       info.Start,
+		End:         end,
+		Node:        info.Node,
+		Email:       info.User,
+		Source:      info.Source,
+		Destination: info.Destination,
+		Protocol:    info.Protocol,
+		Outbound:    info.Outbound,
+		Up:          info.Up,
+		Down:        info.Down,
+	}
+	if _, user, ok := ic.ParseUserEmail(info.User); ok {
+		r.User = user
+	}
+	b, err := json.Marshal(&r)
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+	l.access.Lock()
+	_, _ = l.w.Write(b)
+	l.access.Unlock()
+}
@@ -0,0 +1,58 @@
+package dispatcher
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+type syncBuffer struct {
+	access sync.Mutex
+	bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.access.Lock()
+	defer b.access.Unlock()
+	return b.Buffer.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.access.Lock()
+	defer b.access.Unlock()
+	return append([]byte(nil), b.Buffer.Bytes()...)
+}
+
+func TestDefaultDispatcher_logAccess(t *testing.T) {
+	d := &DefaultDispatcher{ct: NewConnTracker()}
+	d.ct.OnClose = d.logAccess
+	out := &syncBuffer{}
+	d.SetAccessLog(out)
+	ctx, cancel := context.WithCancel(context.Background())
+	c := d.ct.track(newSessionEnd(ctx), "[a](node)", "node", "1.1.1.1", "tcp:a.com:443")
+	c.setProtocol("tls")
+	c.setOutbound("node_out")
+	c.up.Add(10)
+	c.down.Add(20)
+	cancel()
+	var line []byte
+	for i := 0; i < 100 && len(line) == 0; i++ {
+		time.Sleep(time.Millisecond)
+		line = out.Bytes()
+	}
+	var r AccessRecord
+	if err := json.Unmarshal(line, &r); err != nil {
+		t.Fatalf("invalid access log %q: %v", line, err)
+	}
+	if r.Node != "node" || r.User != "a" || r.Source != "1.1.1.1" || r.Destination != "tcp:a.com:443" ||
+		r.Protocol != "tls" || r.Outbound != "node_out" || r.Up != 10 || r.Down != 20 {
+		t.Errorf("unexpected access record: %+v", r)
+	}
+	if bytes.Count(line, []byte("\n")) != 1 {
+		t.Errorf("want one line, got %q", line)
+	}
+}
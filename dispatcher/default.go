@@ -2,12 +2,13 @@ package dispatcher
 
 import (
 	"context"
-	ic "github.com/InazumaV/Ratte-Core-Xray/common"
+	"github.com/InazumaV/Ratte-Core-Xray/event"
 	"github.com/InazumaV/Ratte-Core-Xray/limiter"
 	cmap "github.com/orcaman/concurrent-map/v2"
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/xtls/xray-core/common"
@@ -17,8 +18,10 @@ import (
 	"github.com/xtls/xray-core/common/net"
 	"github.com/xtls/xray-core/common/protocol"
 	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/common/task"
 	"github.com/xtls/xray-core/core"
 	"github.com/xtls/xray-core/features/dns"
+	"github.com/xtls/xray-core/features/extension"
 	"github.com/xtls/xray-core/features/outbound"
 	"github.com/xtls/xray-core/features/policy"
 	"github.com/xtls/xray-core/features/routing"
@@ -105,7 +108,15 @@ type DefaultDispatcher struct {
 	fdns   dns.FakeDNSEngine
 
 	// Modify -------------------------------------
-	ls cmap.ConcurrentMap[string, *limiter.Limiter]
+	ls        cmap.ConcurrentMap[string, *limiter.Limiter]
+	ct        *ConnTracker
+	tt        *task.Periodic
+	events    atomic.Pointer[event.Bus]
+	accessLog atomic.Pointer[accessLog]
+	obs       extension.Observatory
+	ht        *task.Periodic
+	health    atomic.Pointer[map[string]OutboundHealth]
+	fo        cmap.ConcurrentMap[string, []string]
 	// --------------------------------------------
 }
 
@@ -116,6 +127,11 @@ func init() {
 			core.OptionalFeatures(ctx, func(fdns dns.FakeDNSEngine) {
 				d.fdns = fdns
 			})
+			// Modify -------------------------------------
+			core.OptionalFeatures(ctx, func(obs extension.Observatory) {
+				d.obs = obs
+			})
+			// -------------------------------------
 			return d.Init(config.(*Config), om, router, pm, sm, dc)
 		}); err != nil {
 			return nil, err
@@ -132,6 +148,23 @@ func (d *DefaultDispatcher) Init(config *Config, om outbound.Manager, router rou
 	d.stats = sm
 	d.dns = dns
 	d.ls = cmap.New[*limiter.Limiter]()
+	d.ct = NewConnTracker()
+	d.ct.OnOnline = func(_, email, source string) {
+		d.publishUser(event.TypeUserOnline, email, source, "")
+	}
+	d.ct.OnOffline = func(_, email string) {
+		d.publishUser(event.TypeUserOffline, email, "", "")
+	}
+	d.ct.OnClose = d.logAccess
+	d.tt = &task.Periodic{
+		Interval: trafficInterval,
+		Execute:  d.updateTraffic,
+	}
+	d.ht = &task.Periodic{
+		Interval: healthInterval,
+		Execute:  d.updateHealth,
+	}
+	d.fo = cmap.New[[]string]()
 	return nil
 }
 
@@ -141,14 +174,57 @@ func (*DefaultDispatcher) Type() interface{} {
 }
 
 // Start implements common.Runnable.
-func (*DefaultDispatcher) Start() error {
-	return nil
+func (d *DefaultDispatcher) Start() error {
+	if err := d.tt.Start(); err != nil {
+		return err
+	}
+	return d.ht.Start()
 }
 
 // Close implements common.Closable.
-func (*DefaultDispatcher) Close() error { return nil }
+func (d *DefaultDispatcher) Close() error {
+	return errors.Combine(d.tt.Close(), d.ht.Close())
+}
+
+func (d *DefaultDispatcher) getLink(ctx context.Context) (context.Context, *transport.Link, *transport.Link, error) {
+	sessionInbound := session.InboundFromContext(ctx)
+	var user *protocol.MemoryUser
+	if sessionInbound != nil {
+		user = sessionInbound.User
+	}
+
+	// Modify -------------------------------------
+	var l *limiter.Limiter
+	var end *sessionEnd
+	if sessionInbound != nil {
+		l, _ = d.ls.Get(sessionInbound.Tag)
+	}
+	if l != nil && sessionInbound.Source.IsValid() {
+		var email string
+		if user != nil {
+			email = user.Email
+		}
+		err := d.checkSource(ctx, l, sessionInbound.Tag, email, sessionInbound.Source.Address.String())
+		if err != nil {
+			return ctx, nil, nil, err
+		}
+	}
+	if user != nil && len(user.Email) > 0 {
+		var release func()
+		if l != nil {
+			var err error
+			release, err = d.checkLimit(ctx, l, user.Email, sessionInbound.Source.Address.String())
+			if err != nil {
+				return ctx, nil, nil, err
+			}
+		}
+		end = newSessionEnd(ctx)
+		if release != nil {
+			end.add(release)
+		}
+	}
+	// -------------------------------------
 
-func (d *DefaultDispatcher) getLink(ctx context.Context) (*transport.Link, *transport.Link) {
 	opt := pipe.OptionsFromContext(ctx)
 	uplinkReader, uplinkWriter := pipe.New(opt...)
 	downlinkReader, downlinkWriter := pipe.New(opt...)
@@ -163,23 +239,12 @@ func (d *DefaultDispatcher) getLink(ctx context.Context) (*transport.Link, *tran
 		Writer: downlinkWriter,
 	}
 
-	sessionInbound := session.InboundFromContext(ctx)
-	var user *protocol.MemoryUser
-	if sessionInbound != nil {
-		user = sessionInbound.User
-	}
-
 	if user != nil && len(user.Email) > 0 {
 		// Modify -------------------------------------
-		l, ok := d.ls.Get(sessionInbound.Tag)
-		if ok {
-			// speed limit check
-			b, err := l.CheckSpeedLimitTheGetRateLimiter(user.Email)
-			if err != nil {
-				errors.LogWarning(ctx, "Check speed limit error: ", err)
-			}
-			inboundLink.Writer = limiter.NewRateLimitWriter(inboundLink.Writer, b)
-			outboundLink.Writer = limiter.NewRateLimitWriter(outboundLink.Writer, b)
+		if l != nil {
+			// speed limit of the user and the node
+			inboundLink.Writer = l.NewWriter(ctx, user.Email, limiter.Uplink, inboundLink.Writer)
+			outboundLink.Writer = l.NewWriter(ctx, user.Email, limiter.Downlink, outboundLink.Writer)
 		}
 		// -------------------------------------
 
@@ -190,20 +255,7 @@ func (d *DefaultDispatcher) getLink(ctx context.Context) (*transport.Link, *tran
 			name := "user>>>" + user.Email + ">>>online"
 			om, _ := stats.GetOrRegisterOnlineMap(d.stats, name)
 			if om != nil {
-				sessionInbounds := session.InboundFromContext(ctx)
-				userIP := sessionInbounds.Source.Address.String()
-				// log Online user with ips
-				// errors.LogDebug(ctx, "user>>>" + user.Email + ">>>online", om.Count(), om.List())
-				if l.CheckIpByCount(user.Email, om.Count()) {
-					if !ic.InSlice(om.List(), userIP) {
-						common.Close(outboundLink.Writer)
-						common.Close(inboundLink.Writer)
-						common.Interrupt(outboundLink.Reader)
-						common.Interrupt(inboundLink.Reader)
-						errors.LogWarning(ctx, "Reject user[", user.Email, "] connect by IP limit.")
-					}
-				}
-				om.AddIP(userIP)
+				om.AddIP(sessionInbound.Source.Address.String())
 			}
 		}
 		// -------------------------------------
@@ -226,9 +278,53 @@ func (d *DefaultDispatcher) getLink(ctx context.Context) (*transport.Link, *tran
 				}
 			}
 		}
+
+		// Modify -------------------------------------
+		var dest string
+		if outbounds := session.OutboundsFromContext(ctx); len(outbounds) > 0 {
+			dest = outbounds[len(outbounds)-1].Target.String()
+		}
+		c := d.ct.track(end, user.Email, sessionInbound.Tag, sessionInbound.Source.Address.String(), dest,
+			uplinkReader, uplinkWriter, downlinkReader, downlinkWriter)
+		var quota func() *limiter.Quota
+		var onExhausted func()
+		var onWrite func(n int64)
+		if l != nil {
+			email := user.Email
+			quota = func() *limiter.Quota {
+				return l.GetQuota(email)
+			}
+			onExhausted = d.quotaExhausted(ctx, sessionInbound.Tag, email)
+			onWrite = func(n int64) {
+				l.AddTraffic(email, n)
+			}
+		}
+		inboundLink.Writer = &SizeStatWriter{
+			Counter:     &c.up,
+			Writer:      inboundLink.Writer,
+			Quota:       quota,
+			OnExhausted: onExhausted,
+			OnWrite:     onWrite,
+		}
+		outboundLink.Writer = &SizeStatWriter{
+			Counter:     &c.down,
+			Writer:      outboundLink.Writer,
+			Quota:       quota,
+			OnExhausted: onExhausted,
+			OnWrite:     onWrite,
+		}
+		ctx = contextWithConn(ctx, c)
+		// -------------------------------------
+	}
+
+	// Modify -------------------------------------
+	if end != nil {
+		inboundLink.Writer = end.wrap(inboundLink.Writer)
+		outboundLink.Writer = end.wrap(outboundLink.Writer)
 	}
+	// -------------------------------------
 
-	return inboundLink, outboundLink
+	return ctx, inboundLink, outboundLink, nil
 }
 
 func (d *DefaultDispatcher) shouldOverride(ctx context.Context, result SniffResult, request session.SniffingRequest, destination net.Destination) bool {
@@ -296,11 +392,10 @@ func (d *DefaultDispatcher) Dispatch(ctx context.Context, destination net.Destin
 	}
 
 	sniffingRequest := content.SniffingRequest
-	inbound, outbound := d.getLink(ctx)
-
 	// Modify -------------------------------------
-	if inbound == nil || outbound == nil {
-		return nil, errors.New("rejected get link")
+	ctx, inbound, outbound, err := d.getLink(ctx)
+	if err != nil {
+		return nil, err
 	}
 	// -------------------------------------------
 	if !sniffingRequest.Enabled {
@@ -314,11 +409,21 @@ func (d *DefaultDispatcher) Dispatch(ctx context.Context, destination net.Destin
 			result, err := sniffer(ctx, cReader, sniffingRequest.MetadataOnly, destination.Network)
 			if err == nil {
 				content.Protocol = result.Protocol()
+				// Modify -------------------------------------
+				if c := connFromContext(ctx); c != nil {
+					c.setProtocol(content.Protocol)
+				}
+				// -------------------------------------
 			}
 			if err == nil && d.shouldOverride(ctx, result, sniffingRequest, destination) {
 				domain := result.Domain()
 				errors.LogInfo(ctx, "sniffed domain: ", domain)
 				destination.Address = net.ParseAddress(domain)
+				// Modify -------------------------------------
+				if c := connFromContext(ctx); c != nil {
+					c.setDestination(destination.String())
+				}
+				// -------------------------------------
 				protocol := result.Protocol()
 				if resComp, ok := result.(SnifferResultComposite); ok {
 					protocol = resComp.ProtocolForDomainResult()
@@ -503,6 +608,9 @@ func (d *DefaultDispatcher) routedDispatch(ctx context.Context, link *transport.
 					errors.LogInfo(ctx, "taking detour [", outTag, "] for [", destination, "]")
 				} else {
 					errors.LogInfo(ctx, "Hit route rule: [", route.GetRuleTag(), "] so taking detour [", outTag, "] for [", destination, "]")
+					// Modify -------------------------------------
+					d.publishRuleHit(ctx, inTag, route.GetRuleTag(), outTag, destination)
+					// -------------------------------------
 				}
 				handler = h
 			} else {
@@ -515,7 +623,7 @@ func (d *DefaultDispatcher) routedDispatch(ctx context.Context, link *transport.
 
 	// Modify ------------------------------------------
 	if handler == nil {
-		handler = d.ohm.GetHandler(ic.FormatDefaultOutboundName(inTag))
+		handler = d.nodeOutbound(inTag)
 	}
 
 	if handler == nil {
@@ -535,6 +643,11 @@ func (d *DefaultDispatcher) routedDispatch(ctx context.Context, link *transport.
 	}
 
 	ob.Tag = handler.Tag()
+	// Modify -------------------------------------
+	if c := connFromContext(ctx); c != nil {
+		c.setOutbound(ob.Tag)
+	}
+	// -------------------------------------
 	if accessMessage := log.AccessMessageFromContext(ctx); accessMessage != nil {
 		if tag := handler.Tag(); tag != "" {
 			if inTag == "" {
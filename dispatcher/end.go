@@ -0,0 +1,89 @@
+package dispatcher
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/buf"
+)
+
+// sessionEnd runs the cleanups of a session once, when both directions of it
+// are closed, one of them is interrupted, or its ctx is done.
+// Every stream of a mux connection is dispatched with the ctx of the connection,
+// so only the pipes tell when a single stream ends.
+type sessionEnd struct {
+	once     sync.Once
+	closed   atomic.Int32
+	access   sync.Mutex
+	ended    bool
+	cleanups []func()
+	stop     func() bool
+}
+
+func newSessionEnd(ctx context.Context) *sessionEnd {
+	e := &sessionEnd{}
+	e.access.Lock()
+	e.stop = context.AfterFunc(ctx, e.end)
+	e.access.Unlock()
+	return e
+}
+
+// add registers f to run when the session ends, it runs at once if the session has ended.
+func (e *sessionEnd) add(f func()) {
+	e.access.Lock()
+	if !e.ended {
+		e.cleanups = append(e.cleanups, f)
+		e.access.Unlock()
+		return
+	}
+	e.access.Unlock()
+	f()
+}
+
+func (e *sessionEnd) end() {
+	e.once.Do(func() {
+		e.access.Lock()
+		e.ended = true
+		cleanups := e.cleanups
+		e.cleanups = nil
+		e.access.Unlock()
+		e.stop()
+		for _, f := range cleanups {
+			f()
+		}
+	})
+}
+
+// closeOne is called when one direction of the session is closed.
+func (e *sessionEnd) closeOne() {
+	if e.closed.Add(1) == 2 {
+		e.end()
+	}
+}
+
+// wrap returns the writer of one direction of the session,
+// reporting to e when it is closed or interrupted.
+func (e *sessionEnd) wrap(w buf.Writer) buf.Writer {
+	return &endWriter{Writer: w, end: e}
+}
+
+type endWriter struct {
+	buf.Writer
+	end    *sessionEnd
+	closed atomic.Bool
+}
+
+func (w *endWriter) Close() error {
+	err := common.Close(w.Writer)
+	if w.closed.CompareAndSwap(false, true) {
+		w.end.closeOne()
+	}
+	return err
+}
+
+func (w *endWriter) Interrupt() {
+	common.Interrupt(w.Writer)
+	w.end.end()
+}
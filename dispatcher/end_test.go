@@ -0,0 +1,56 @@
+package dispatcher
+
+import (
+	"context"
+	"testing"
+
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+func TestSessionEnd(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// both directions closed
+	ended := 0
+	e := newSessionEnd(ctx)
+	e.add(func() { ended++ })
+	_, uw := pipe.New()
+	_, dw := pipe.New()
+	up, down := e.wrap(uw), e.wrap(dw)
+	_ = up.(*endWriter).Close()
+	_ = up.(*endWriter).Close()
+	if ended != 0 {
+		t.Fatal("ended with one direction open")
+	}
+	_ = down.(*endWriter).Close()
+	if ended != 1 {
+		t.Fatalf("ended %d times after both directions closed", ended)
+	}
+	cancel()
+	if ended != 1 {
+		t.Fatal("ended again by ctx")
+	}
+	e.add(func() { ended++ })
+	if ended != 2 {
+		t.Fatal("cleanup added after the end is not run")
+	}
+
+	// interrupted
+	ended = 0
+	e = newSessionEnd(context.Background())
+	e.add(func() { ended++ })
+	_, w := pipe.New()
+	e.wrap(w).(*endWriter).Interrupt()
+	if ended != 1 {
+		t.Fatalf("ended %d times after interrupted", ended)
+	}
+
+	// ctx done
+	ctx, cancel = context.WithCancel(context.Background())
+	done := make(chan struct{})
+	e = newSessionEnd(ctx)
+	e.add(func() { close(done) })
+	cancel()
+	<-done
+}
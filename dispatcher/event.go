@@ -0,0 +1,56 @@
+package dispatcher
+
+import (
+	"context"
+
+	ic "github.com/InazumaV/Ratte-Core-Xray/common"
+	"github.com/InazumaV/Ratte-Core-Xray/event"
+	"github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+)
+
+// SetEventBus sets the bus the dispatcher publishes events of users to, nil disables them.
+func (d *DefaultDispatcher) SetEventBus(b *event.Bus) {
+	d.events.Store(b)
+}
+
+func (d *DefaultDispatcher) publish(e event.Event) {
+	d.events.Load().Publish(e)
+}
+
+// publishUser publishes an event of the user with the email.
+func (d *DefaultDispatcher) publishUser(typ, email, ip, detail string) {
+	node, user, ok := ic.ParseUserEmail(email)
+	if !ok {
+		user = email
+	}
+	d.publish(event.Event{
+		Type:   typ,
+		Node:   node,
+		User:   user,
+		Ip:     ip,
+		Detail: detail,
+	})
+}
+
+func (d *DefaultDispatcher) publishRuleHit(ctx context.Context, node, rule, outbound string, dest net.Destination) {
+	if d.events.Load() == nil {
+		return
+	}
+	e := event.Event{
+		Type:   event.TypeRuleHit,
+		Node:   node,
+		Detail: rule + " -> " + outbound + " for " + dest.String(),
+	}
+	if inbound := session.InboundFromContext(ctx); inbound != nil {
+		if inbound.User != nil {
+			if _, user, ok := ic.ParseUserEmail(inbound.User.Email); ok {
+				e.User = user
+			}
+		}
+		if inbound.Source.IsValid() {
+			e.Ip = inbound.Source.Address.String()
+		}
+	}
+	d.publish(e)
+}
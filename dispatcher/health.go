@@ -0,0 +1,146 @@
+package dispatcher
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	ic "github.com/InazumaV/Ratte-Core-Xray/common"
+	"github.com/InazumaV/Ratte-Core-Xray/event"
+	"github.com/xtls/xray-core/app/observatory"
+	"github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/features/outbound"
+)
+
+const healthInterval = 2 * time.Second
+
+// OutboundHealth is the latest probe result of an outbound from the observatory.
+type OutboundHealth struct {
+	Tag   string
+	Alive bool
+	// Delay is the round trip time of the last successful probe in milliseconds
+	Delay     int64
+	LastError string
+	LastSeen  time.Time
+	LastTry   time.Time
+}
+
+// SetFailover sets the outbounds used in order when the default outbound of the node is not alive.
+func (d *DefaultDispatcher) SetFailover(nodeName string, tags []string) {
+	if len(tags) == 0 {
+		d.fo.Remove(nodeName)
+		return
+	}
+	d.fo.Set(nodeName, tags)
+}
+
+func (d *DefaultDispatcher) GetFailover(nodeName string) ([]string, bool) {
+	return d.fo.Get(nodeName)
+}
+
+func (d *DefaultDispatcher) RemoveFailover(nodeName string) {
+	d.fo.Remove(nodeName)
+}
+
+// GetOutboundHealth returns the health of the outbounds probed by the observatory, sorted by tag.
+// It is empty if no observatory is configured.
+func (d *DefaultDispatcher) GetOutboundHealth() []OutboundHealth {
+	hs := d.health.Load()
+	if hs == nil {
+		return []OutboundHealth{}
+	}
+	r := make([]OutboundHealth, 0, len(*hs))
+	for _, h := range *hs {
+		r = append(r, h)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Tag < r[j].Tag
+	})
+	return r
+}
+
+// alive reports whether the outbound is usable, outbounds not probed are taken as alive.
+func (d *DefaultDispatcher) alive(tag string) bool {
+	hs := d.health.Load()
+	if hs == nil {
+		return true
+	}
+	h, ok := (*hs)[tag]
+	return !ok || h.Alive
+}
+
+// nodeOutbound returns the default outbound of the node, or the first alive failover
+// outbound if it is down. The default outbound is kept if none of them is alive.
+func (d *DefaultDispatcher) nodeOutbound(nodeName string) outbound.Handler {
+	tag := ic.FormatDefaultOutboundName(nodeName)
+	if !d.alive(tag) {
+		if tags, ok := d.fo.Get(nodeName); ok {
+			for _, t := range tags {
+				if !d.alive(t) {
+					continue
+				}
+				if h := d.ohm.GetHandler(t); h != nil {
+					return h
+				}
+			}
+		}
+	}
+	return d.ohm.GetHandler(tag)
+}
+
+// updateHealth caches the observation of the observatory,
+// and publishes an event for every outbound going down or up.
+func (d *DefaultDispatcher) updateHealth() error {
+	if d.obs == nil {
+		return nil
+	}
+	r, err := d.obs.GetObservation(context.Background())
+	if err != nil {
+		errors.LogWarningInner(context.Background(), err, "failed to get observation")
+		return nil
+	}
+	result, ok := r.(*observatory.ObservationResult)
+	if !ok {
+		return nil
+	}
+	hs := make(map[string]OutboundHealth, len(result.Status))
+	for _, s := range result.Status {
+		h := OutboundHealth{
+			Tag:       s.OutboundTag,
+			Alive:     s.Alive,
+			LastError: s.LastErrorReason,
+		}
+		if s.Alive {
+			h.Delay = s.Delay
+		}
+		if s.LastSeenTime != 0 {
+			h.LastSeen = time.Unix(s.LastSeenTime, 0)
+		}
+		if s.LastTryTime != 0 {
+			h.LastTry = time.Unix(s.LastTryTime, 0)
+		}
+		hs[h.Tag] = h
+	}
+	old := d.health.Swap(&hs)
+	for tag, h := range hs {
+		was := true
+		if old != nil {
+			if o, ok := (*old)[tag]; ok {
+				was = o.Alive
+			}
+		}
+		if was == h.Alive {
+			continue
+		}
+		e := event.Event{
+			Type:   event.TypeOutboundUp,
+			Detail: tag,
+		}
+		if !h.Alive {
+			e.Type = event.TypeOutboundDown
+			e.Detail = tag + ": " + h.LastError
+		}
+		d.publish(e)
+	}
+	return nil
+}
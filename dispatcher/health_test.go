@@ -0,0 +1,94 @@
+package dispatcher
+
+import (
+	"context"
+	"testing"
+
+	"github.com/InazumaV/Ratte-Core-Xray/event"
+	cmap "github.com/orcaman/concurrent-map/v2"
+	"github.com/xtls/xray-core/app/observatory"
+	"github.com/xtls/xray-core/features/extension"
+	"github.com/xtls/xray-core/features/outbound"
+	"google.golang.org/protobuf/proto"
+)
+
+type testObservatory struct {
+	extension.Observatory
+	status []*observatory.OutboundStatus
+}
+
+func (o *testObservatory) GetObservation(context.Context) (proto.Message, error) {
+	return &observatory.ObservationResult{Status: o.status}, nil
+}
+
+type testHandler struct {
+	outbound.Handler
+	tag string
+}
+
+func (h *testHandler) Tag() string {
+	return h.tag
+}
+
+type testOutboundManager struct {
+	outbound.Manager
+}
+
+func (testOutboundManager) GetHandler(tag string) outbound.Handler {
+	return &testHandler{tag: tag}
+}
+
+func TestDefaultDispatcher_nodeOutbound(t *testing.T) {
+	obs := &testObservatory{}
+	d := &DefaultDispatcher{
+		ohm: testOutboundManager{},
+		obs: obs,
+		fo:  cmap.New[[]string](),
+	}
+	bus := event.NewBus(0)
+	d.SetEventBus(bus)
+	d.SetFailover("n1", []string{"a", "b"})
+
+	if tag := d.nodeOutbound("n1").Tag(); tag != "n1_out" {
+		t.Fatalf("outbound should be the default before probing, got %s", tag)
+	}
+	obs.status = []*observatory.OutboundStatus{
+		{OutboundTag: "n1_out", Alive: false, LastErrorReason: "timeout"},
+		{OutboundTag: "a", Alive: false},
+		{OutboundTag: "b", Alive: true, Delay: 20},
+	}
+	_ = d.updateHealth()
+	if tag := d.nodeOutbound("n1").Tag(); tag != "b" {
+		t.Fatalf("outbound should fail over to b, got %s", tag)
+	}
+	if tag := d.nodeOutbound("n2").Tag(); tag != "n2_out" {
+		t.Fatalf("node without failover should keep its default, got %s", tag)
+	}
+	hs := d.GetOutboundHealth()
+	if len(hs) != 3 || hs[0].Tag != "a" || hs[1].Tag != "b" || hs[1].Delay != 20 || hs[2].LastError != "timeout" {
+		t.Fatalf("unexpected health: %+v", hs)
+	}
+	es, _ := bus.Poll(0, 0)
+	if len(es) != 2 || es[0].Type != event.TypeOutboundDown || es[1].Type != event.TypeOutboundDown {
+		t.Fatalf("expected two outbound_down events, got %+v", es)
+	}
+
+	obs.status[1].Alive = true
+	obs.status[2].Alive = false
+	_ = d.updateHealth()
+	if tag := d.nodeOutbound("n1").Tag(); tag != "a" {
+		t.Fatalf("outbound should fail over to a, got %s", tag)
+	}
+	obs.status[1].Alive = false
+	_ = d.updateHealth()
+	if tag := d.nodeOutbound("n1").Tag(); tag != "n1_out" {
+		t.Fatalf("default should be kept when all are dead, got %s", tag)
+	}
+	d.RemoveFailover("n1")
+	obs.status[0].Alive = true
+	_ = d.updateHealth()
+	es, _ = bus.Poll(2, 0)
+	if len(es) != 4 || es[3].Type != event.TypeOutboundUp || es[3].Detail != "n1_out" {
+		t.Fatalf("unexpected events: %+v", es)
+	}
+}
@@ -1,13 +1,121 @@
 package dispatcher
 
-import "github.com/InazumaV/Ratte-Core-Xray/limiter"
+import (
+	"context"
+	"sync"
+	"time"
+
+	ic "github.com/InazumaV/Ratte-Core-Xray/common"
+	"github.com/InazumaV/Ratte-Core-Xray/event"
+	"github.com/InazumaV/Ratte-Core-Xray/limiter"
+	"github.com/xtls/xray-core/common/errors"
+)
+
+// trafficInterval is how often the traffic of users is fed to the limiters.
+const trafficInterval = 10 * time.Second
 
 func (d *DefaultDispatcher) AddLimiter(nodeName string, l *limiter.Limiter) error {
 	d.ls.Set(nodeName, l)
 	return nil
 }
 
+func (d *DefaultDispatcher) GetLimiter(nodeName string) (*limiter.Limiter, bool) {
+	return d.ls.Get(nodeName)
+}
+
 func (d *DefaultDispatcher) RemoveLimiter(nodeName string) error {
 	d.ls.Remove(nodeName)
 	return nil
 }
+
+// reject counts a connection rejected for reason.
+func (d *DefaultDispatcher) reject(l *limiter.Limiter, email, ip, reason string) {
+	l.AddReject(reason)
+	d.publishUser(event.TypeLimitReject, email, ip, reason)
+}
+
+// checkSource decides whether a new session from ip may be created on the node
+// by the source and region filters, it applies to every session of the node,
+// with or without a user.
+func (d *DefaultDispatcher) checkSource(ctx context.Context, l *limiter.Limiter, node, email, ip string) error {
+	if l.CheckSource(ip) {
+		l.AddReject(limiter.RejectSource)
+		d.publishSourceReject(node, email, ip, limiter.RejectSource)
+		errors.LogWarning(ctx, "Reject connection from ", ip, " to node ", node, " by source filter.")
+		return errors.New("reject connection from ", ip, " by source filter")
+	}
+	if region, reject := l.CheckRegion(ip); reject {
+		l.AddRegionReject(region)
+		d.publishSourceReject(node, email, ip, limiter.RejectRegion+":"+region)
+		errors.LogWarning(ctx, "Reject connection from ", ip, " to node ", node, " by region ", region, ".")
+		return errors.New("reject connection from ", ip, " by region")
+	}
+	return nil
+}
+
+// publishSourceReject publishes the rejection of a session on the node,
+// the user is left empty for a session without one.
+func (d *DefaultDispatcher) publishSourceReject(node, email, ip, reason string) {
+	e := event.Event{Type: event.TypeLimitReject, Node: node, Ip: ip, Detail: reason}
+	if _, user, ok := ic.ParseUserEmail(email); ok {
+		e.User = user
+	}
+	d.publish(e)
+}
+
+// checkLimit decides whether a new session of the user may be created,
+// it must run before any link is created. The returned release must be
+// called when the session ends.
+func (d *DefaultDispatcher) checkLimit(ctx context.Context, l *limiter.Limiter, email, ip string) (release func(), err error) {
+	if b := l.CheckBan(email, ip); b != nil {
+		d.reject(l, email, ip, limiter.RejectBanned)
+		errors.LogWarning(ctx, "Reject user[", email, "] connect from ", ip, " by ban until ",
+			b.Until.Format(time.RFC3339), ": ", b.Reason)
+		return nil, errors.New("reject user[", email, "] connect by ban")
+	}
+	if l.CheckQuota(email) {
+		d.reject(l, email, ip, limiter.RejectQuota)
+		errors.LogWarning(ctx, "Reject user[", email, "] connect by traffic quota.")
+		return nil, errors.New("reject user[", email, "] connect by traffic quota")
+	}
+	if l.CheckConnLimitThenAdd(email) {
+		d.reject(l, email, ip, limiter.RejectConnLimit)
+		errors.LogWarning(ctx, "Reject user[", email, "] connect by connection limit.")
+		return nil, errors.New("reject user[", email, "] connect by connection limit")
+	}
+	reject, err := l.CheckIpLimitThenRecord(email, ip)
+	if err != nil {
+		errors.LogWarningInner(ctx, err, "Check IP limit error")
+	}
+	if reject {
+		l.ReleaseConn(email)
+		d.reject(l, email, ip, limiter.RejectIpLimit)
+		errors.LogWarning(ctx, "Reject user[", email, "] connect by IP limit.")
+		return nil, errors.New("reject user[", email, "] connect by IP limit")
+	}
+	return func() {
+		l.ReleaseConn(email)
+		if err := l.ReleaseIp(email, ip); err != nil {
+			errors.LogWarningInner(ctx, err, "Release IP error")
+		}
+	}, nil
+}
+
+// quotaExhausted returns the callback cutting off the user
+// once its traffic quota is used up.
+func (d *DefaultDispatcher) quotaExhausted(ctx context.Context, node, email string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d.publishUser(event.TypeQuotaExhausted, email, "", "")
+			errors.LogWarning(ctx, "User[", email, "] used up traffic quota, kick ", d.KickUser(node, email), " connections.")
+		})
+	}
+}
+
+func (d *DefaultDispatcher) updateTraffic() error {
+	for item := range d.ls.IterBuffered() {
+		item.Val.UpdateTraffic()
+	}
+	return nil
+}
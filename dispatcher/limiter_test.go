@@ -0,0 +1,172 @@
+package dispatcher
+
+import (
+	"context"
+	"testing"
+
+	"github.com/InazumaV/Ratte-Core-Xray/limiter"
+	"github.com/InazumaV/Ratte-Interface/params"
+	cmap "github.com/orcaman/concurrent-map/v2"
+	"github.com/xtls/xray-core/common/buf"
+	"github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/features/policy"
+)
+
+func TestDefaultDispatcher_getLink_IpLimit(t *testing.T) {
+	d := &DefaultDispatcher{
+		ls: cmap.New[*limiter.Limiter](),
+		ct: NewConnTracker(),
+	}
+	l := limiter.NewLimiter(1, 0, 0, nil, nil)
+	_ = d.AddLimiter("node", l)
+	email := "[a](node)"
+	if reject, _ := l.CheckIpLimitThenRecord(email, "1.1.1.1"); reject {
+		t.Fatal("first ip should be accepted")
+	}
+	ctx := session.ContextWithInbound(context.Background(), &session.Inbound{
+		Source: net.TCPDestination(net.ParseAddress("2.2.2.2"), 1234),
+		Tag:    "node",
+		User:   &protocol.MemoryUser{Email: email},
+	})
+	_, in, out, err := d.getLink(ctx)
+	if err == nil || in != nil || out != nil {
+		t.Fatal("connection over ip limit should be rejected before creating links")
+	}
+}
+
+func TestDefaultDispatcher_getLink_Quota(t *testing.T) {
+	d := &DefaultDispatcher{
+		ls:     cmap.New[*limiter.Limiter](),
+		ct:     NewConnTracker(),
+		policy: policy.DefaultManager{},
+	}
+	l := limiter.NewLimiter(0, 0, 0, nil, nil)
+	_ = d.AddLimiter("node", l)
+	email := "[a](node)"
+	l.SetQuota(email, 10)
+	newCtx := func() context.Context {
+		return session.ContextWithInbound(context.Background(), &session.Inbound{
+			Source: net.TCPDestination(net.ParseAddress("1.1.1.1"), 1234),
+			Tag:    "node",
+			User:   &protocol.MemoryUser{Email: email},
+		})
+	}
+	ctx, cancel := context.WithCancel(newCtx())
+	defer cancel()
+	_, in, out, err := d.getLink(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = in.Writer.WriteMultiBuffer(buf.MergeBytes(nil, []byte("12345678"))); err != nil {
+		t.Fatal(err)
+	}
+	if err = in.Writer.WriteMultiBuffer(buf.MergeBytes(nil, []byte("12345"))); err == nil {
+		t.Fatal("write over the quota should fail")
+	}
+	if _, err = out.Reader.ReadMultiBuffer(); err == nil {
+		t.Error("connection should be kicked once the quota is used up")
+	}
+	if _, _, _, err = d.getLink(newCtx()); err == nil {
+		t.Fatal("connection of a user out of quota should be rejected")
+	}
+	if got := l.GetRejects()[limiter.RejectQuota]; got != 1 {
+		t.Errorf("quota rejects = %d, want 1", got)
+	}
+	l.SetQuota(email, 100)
+	ctx2, cancel2 := context.WithCancel(newCtx())
+	defer cancel2()
+	if _, _, _, err = d.getLink(ctx2); err != nil {
+		t.Fatalf("connection after the quota is renewed should be accepted: %v", err)
+	}
+}
+
+func TestDefaultDispatcher_getLink_QuotaSetLater(t *testing.T) {
+	d := &DefaultDispatcher{
+		ls:     cmap.New[*limiter.Limiter](),
+		ct:     NewConnTracker(),
+		policy: policy.DefaultManager{},
+	}
+	l := limiter.NewLimiter(0, 0, 0, nil, nil)
+	_ = d.AddLimiter("node", l)
+	email := "[a](node)"
+	ctx, cancel := context.WithCancel(session.ContextWithInbound(context.Background(), &session.Inbound{
+		Source: net.TCPDestination(net.ParseAddress("1.1.1.1"), 1234),
+		Tag:    "node",
+		User:   &protocol.MemoryUser{Email: email},
+	}))
+	defer cancel()
+	_, in, _, err := d.getLink(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = in.Writer.WriteMultiBuffer(buf.MergeBytes(nil, []byte("12345678"))); err != nil {
+		t.Fatal(err)
+	}
+	// the quota is set while the connection is live
+	l.SetQuota(email, 5)
+	if err = in.Writer.WriteMultiBuffer(buf.MergeBytes(nil, []byte("12345678"))); err == nil {
+		t.Error("write over a quota set after connecting should fail")
+	}
+}
+
+func TestDefaultDispatcher_getLink_Throttle(t *testing.T) {
+	d := &DefaultDispatcher{
+		ls:     cmap.New[*limiter.Limiter](),
+		ct:     NewConnTracker(),
+		policy: policy.DefaultManager{},
+	}
+	l := limiter.NewLimiter(0, 0, 0, nil, nil)
+	l.Throttle = &limiter.ThrottleConfig{
+		Tiers: []limiter.ThrottleTier{{Traffic: 10, SpeedLimit: 100}},
+	}
+	_ = l.AddUserInfos("node", []params.UserInfo{{Name: "a"}})
+	_ = d.AddLimiter("node", l)
+	email := "[a](node)"
+	ctx, cancel := context.WithCancel(session.ContextWithInbound(context.Background(), &session.Inbound{
+		Source: net.TCPDestination(net.ParseAddress("1.1.1.1"), 1234),
+		Tag:    "node",
+		User:   &protocol.MemoryUser{Email: email},
+	}))
+	defer cancel()
+	_, in, _, err := d.getLink(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err = in.Writer.WriteMultiBuffer(buf.MergeBytes(nil, []byte("12345678"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = d.updateTraffic()
+	if s := l.GetThrottled(); len(s) != 1 || s[0].Traffic != 16 {
+		t.Errorf("throttled = %+v, want the 16 bytes written", s)
+	}
+}
+
+func TestDefaultDispatcher_getLink_Source(t *testing.T) {
+	d := &DefaultDispatcher{
+		ls:     cmap.New[*limiter.Limiter](),
+		ct:     NewConnTracker(),
+		policy: policy.DefaultManager{},
+	}
+	l := limiter.NewLimiter(0, 0, 0, nil, nil)
+	if err := l.UpdateSourceFilter(limiter.SourceFilter{Deny: []string{"1.1.1.0/24"}}); err != nil {
+		t.Fatal(err)
+	}
+	_ = d.AddLimiter("node", l)
+	for _, user := range []*protocol.MemoryUser{{Email: "[a](node)"}, nil} {
+		ctx := session.ContextWithInbound(context.Background(), &session.Inbound{
+			Source: net.TCPDestination(net.ParseAddress("1.1.1.1"), 1234),
+			Tag:    "node",
+			User:   user,
+		})
+		if _, in, out, err := d.getLink(ctx); err == nil || in != nil || out != nil {
+			t.Errorf("session of user %v from a denied source should be rejected", user)
+		}
+	}
+	if n := l.GetRejects()[limiter.RejectSource]; n != 2 {
+		t.Errorf("source rejects = %d", n)
+	}
+}
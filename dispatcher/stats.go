@@ -1,17 +1,44 @@
 package dispatcher
 
 import (
+	"github.com/InazumaV/Ratte-Core-Xray/limiter"
 	"github.com/xtls/xray-core/common"
 	"github.com/xtls/xray-core/common/buf"
+	"github.com/xtls/xray-core/common/errors"
 	"github.com/xtls/xray-core/features/stats"
 )
 
+var errQuotaExhausted = errors.New("traffic quota exhausted")
+
 type SizeStatWriter struct {
 	Counter stats.Counter
 	Writer  buf.Writer
+	// Modify -------------------------------------
+	// Quota returns the budget taken by every write if not nil,
+	// it is looked up on every write so a budget set later applies too.
+	// Once the budget is used up the writes fail and OnExhausted is called
+	Quota       func() *limiter.Quota
+	OnExhausted func()
+	// OnWrite is called with the size of every write let through if not nil
+	OnWrite func(n int64)
+	// -------------------------------------
 }
 
 func (w *SizeStatWriter) WriteMultiBuffer(mb buf.MultiBuffer) error {
+	// Modify -------------------------------------
+	if w.Quota != nil {
+		if q := w.Quota(); q != nil && !q.Consume(int64(mb.Len())) {
+			buf.ReleaseMulti(mb)
+			if w.OnExhausted != nil {
+				w.OnExhausted()
+			}
+			return errQuotaExhausted
+		}
+	}
+	if w.OnWrite != nil {
+		w.OnWrite(int64(mb.Len()))
+	}
+	// -------------------------------------
 	w.Counter.Add(int64(mb.Len()))
 	return w.Writer.WriteMultiBuffer(mb)
 }
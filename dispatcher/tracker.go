@@ -0,0 +1,255 @@
+package dispatcher
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cmap "github.com/orcaman/concurrent-map/v2"
+	"github.com/xtls/xray-core/app/stats"
+	"github.com/xtls/xray-core/common"
+)
+
+// ConnInfo is a snapshot of a live session handled by the dispatcher.
+type ConnInfo struct {
+	ID          uint64
+	User        string
+	Node        string
+	Source      string
+	Destination string
+	Protocol    string
+	Outbound    string
+	Start       time.Time
+	Up          int64
+	Down        int64
+}
+
+type conn struct {
+	access      sync.RWMutex
+	id          uint64
+	user        string
+	node        string
+	source      string
+	destination string
+	protocol    string
+	outbound    string
+	start       time.Time
+	up          stats.Counter
+	down        stats.Counter
+	pipes       []common.Interruptible
+}
+
+func (c *conn) setDestination(dest string) {
+	c.access.Lock()
+	c.destination = dest
+	c.access.Unlock()
+}
+
+func (c *conn) setProtocol(protocol string) {
+	c.access.Lock()
+	c.protocol = protocol
+	c.access.Unlock()
+}
+
+func (c *conn) setOutbound(tag string) {
+	c.access.Lock()
+	c.outbound = tag
+	c.access.Unlock()
+}
+
+// interrupt breaks every pipe of the session, so both the inbound and
+// the outbound side stop immediately.
+func (c *conn) interrupt() {
+	for _, p := range c.pipes {
+		p.Interrupt()
+	}
+}
+
+func (c *conn) info() ConnInfo {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	return ConnInfo{
+		ID:          c.id,
+		User:        c.user,
+		Node:        c.node,
+		Source:      c.source,
+		Destination: c.destination,
+		Protocol:    c.protocol,
+		Outbound:    c.outbound,
+		Start:       c.start,
+		Up:          c.up.Value(),
+		Down:        c.down.Value(),
+	}
+}
+
+// ConnTracker records every live session created by the dispatcher.
+type ConnTracker struct {
+	lastID atomic.Uint64
+	conns  cmap.ConcurrentMap[uint64, *conn]
+	// users counts the live sessions of every user of every node
+	users cmap.ConcurrentMap[string, *int]
+	// OnOnline and OnOffline are called when the first session of a user
+	// on a node starts and the last one ends, if not nil
+	OnOnline  func(node, user, source string)
+	OnOffline func(node, user string)
+	// OnClose is called with the final state of every session and the time
+	// it ended when its pipes are closed, if not nil
+	OnClose func(info ConnInfo, end time.Time)
+}
+
+func NewConnTracker() *ConnTracker {
+	return &ConnTracker{
+		conns: cmap.NewWithCustomShardingFunction[uint64, *conn](func(key uint64) uint32 {
+			return uint32(key)
+		}),
+		users: cmap.New[*int](),
+	}
+}
+
+func userKey(node, user string) string {
+	return node + "|" + user
+}
+
+// add records the session, the callbacks run under the lock of the user
+// so the online and offline events of a user are never reordered.
+func (t *ConnTracker) add(c *conn) {
+	t.conns.Set(c.id, c)
+	t.users.Upsert(userKey(c.node, c.user), nil, func(exist bool, v *int, _ *int) *int {
+		if !exist {
+			v = new(int)
+		}
+		*v++
+		if *v == 1 && t.OnOnline != nil {
+			t.OnOnline(c.node, c.user, c.source)
+		}
+		return v
+	})
+}
+
+// remove forgets the session, it is safe to call more than once.
+func (t *ConnTracker) remove(c *conn) {
+	removed := t.conns.RemoveCb(c.id, func(_ uint64, _ *conn, exists bool) bool {
+		return exists
+	})
+	if !removed {
+		return
+	}
+	t.users.RemoveCb(userKey(c.node, c.user), func(_ string, v *int, exists bool) bool {
+		if !exists {
+			return false
+		}
+		*v--
+		if *v > 0 {
+			return false
+		}
+		if t.OnOffline != nil {
+			t.OnOffline(c.node, c.user)
+		}
+		return true
+	})
+}
+
+// track registers a session and removes it once the session ends.
+func (t *ConnTracker) track(
+	end *sessionEnd,
+	user, node, source, destination string,
+	pipes ...common.Interruptible,
+) *conn {
+	c := &conn{
+		id:          t.lastID.Add(1),
+		user:        user,
+		node:        node,
+		source:      source,
+		destination: destination,
+		start:       time.Now(),
+		pipes:       pipes,
+	}
+	t.add(c)
+	end.add(func() {
+		ended := time.Now()
+		t.remove(c)
+		if t.OnClose != nil {
+			t.OnClose(c.info(), ended)
+		}
+	})
+	return c
+}
+
+// List returns the live sessions matching node and user, empty values match all.
+func (t *ConnTracker) List(node, user string) []ConnInfo {
+	infos := make([]ConnInfo, 0)
+	t.conns.IterCb(func(_ uint64, c *conn) {
+		if node != "" && c.node != node {
+			return
+		}
+		if user != "" && c.user != user {
+			return
+		}
+		infos = append(infos, c.info())
+	})
+	return infos
+}
+
+// Interrupt breaks the live sessions matching node and user, empty values match all.
+// It returns the number of interrupted sessions.
+func (t *ConnTracker) Interrupt(node, user string) int {
+	return t.interrupt(func(c *conn) bool {
+		return (node == "" || c.node == node) && (user == "" || c.user == user)
+	})
+}
+
+// InterruptSource breaks the live sessions from the source ip on the node,
+// an empty node matches all.
+func (t *ConnTracker) InterruptSource(node, source string) int {
+	return t.interrupt(func(c *conn) bool {
+		return (node == "" || c.node == node) && c.source == source
+	})
+}
+
+func (t *ConnTracker) interrupt(match func(c *conn) bool) int {
+	var cs []*conn
+	t.conns.IterCb(func(_ uint64, c *conn) {
+		if match(c) {
+			cs = append(cs, c)
+		}
+	})
+	for _, c := range cs {
+		c.interrupt()
+		t.remove(c)
+	}
+	return len(cs)
+}
+
+// Count returns the number of live sessions.
+func (t *ConnTracker) Count() int {
+	return t.conns.Count()
+}
+
+type connKey struct{}
+
+func contextWithConn(ctx context.Context, c *conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+func connFromContext(ctx context.Context) *conn {
+	if c, ok := ctx.Value(connKey{}).(*conn); ok {
+		return c
+	}
+	return nil
+}
+
+// ListConns returns the live sessions matching node and user, empty values match all.
+func (d *DefaultDispatcher) ListConns(node, user string) []ConnInfo {
+	return d.ct.List(node, user)
+}
+
+// KickUser interrupts every live session of the user on the node.
+func (d *DefaultDispatcher) KickUser(node, user string) int {
+	return d.ct.Interrupt(node, user)
+}
+
+// KickIp interrupts every live session from the ip on the node.
+func (d *DefaultDispatcher) KickIp(node, ip string) int {
+	return d.ct.InterruptSource(node, ip)
+}
@@ -0,0 +1,81 @@
+package dispatcher
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+func TestConnTracker(t *testing.T) {
+	ct := NewConnTracker()
+	ctx, cancel := context.WithCancel(context.Background())
+	c := ct.track(newSessionEnd(ctx), "[a](node)", "node", "1.1.1.1", "tcp:a.com:443")
+	c.up.Add(3)
+	c.setOutbound("node_out")
+	ct.track(newSessionEnd(context.Background()), "[b](node)", "node", "2.2.2.2", "tcp:b.com:443")
+
+	if l := ct.List("node", "[a](node)"); len(l) != 1 || l[0].Up != 3 || l[0].Outbound != "node_out" {
+		t.Fatalf("unexpected conns: %v", l)
+	}
+	if l := ct.List("", ""); len(l) != 2 {
+		t.Fatalf("want 2 conns, got %d", len(l))
+	}
+	cancel()
+	for i := 0; i < 100 && ct.Count() != 1; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if ct.Count() != 1 {
+		t.Fatalf("want 1 conn after cancel, got %d", ct.Count())
+	}
+}
+
+func TestConnTracker_Interrupt(t *testing.T) {
+	ct := NewConnTracker()
+	r, w := pipe.New()
+	ct.track(newSessionEnd(context.Background()), "[a](node)", "node", "1.1.1.1", "tcp:a.com:443", r, w)
+	ct.track(newSessionEnd(context.Background()), "[b](node)", "node", "2.2.2.2", "tcp:b.com:443")
+
+	if n := ct.Interrupt("node", "[a](node)"); n != 1 {
+		t.Fatalf("want 1 interrupted conn, got %d", n)
+	}
+	if _, err := r.ReadMultiBuffer(); err == nil {
+		t.Fatal("reader should be interrupted")
+	}
+	if ct.Count() != 1 {
+		t.Fatalf("want 1 conn left, got %d", ct.Count())
+	}
+}
+
+func TestConnTracker_Online(t *testing.T) {
+	ct := NewConnTracker()
+	var access sync.Mutex
+	var events []string
+	ct.OnOnline = func(node, user, source string) {
+		access.Lock()
+		events = append(events, "online "+user+" "+source)
+		access.Unlock()
+	}
+	ct.OnOffline = func(node, user string) {
+		access.Lock()
+		events = append(events, "offline "+user)
+		access.Unlock()
+	}
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	ct.track(newSessionEnd(ctx1), "[a](node)", "node", "1.1.1.1", "tcp:a.com:443")
+	ct.track(newSessionEnd(ctx2), "[a](node)", "node", "2.2.2.2", "tcp:a.com:443")
+	cancel1()
+	// interrupted sessions are removed once even when their context ends later
+	ct.Interrupt("node", "[a](node)")
+	cancel2()
+	time.Sleep(10 * time.Millisecond)
+	access.Lock()
+	defer access.Unlock()
+	want := []string{"online [a](node) 1.1.1.1", "offline [a](node)"}
+	if len(events) != len(want) || events[0] != want[0] || events[1] != want[1] {
+		t.Fatalf("events = %v, want %v", events, want)
+	}
+}
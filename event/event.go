@@ -0,0 +1,141 @@
+package event
+
+import (
+	"sync"
+	"time"
+)
+
+// Types of events
+const (
+	TypeUserOnline     = "user_online"
+	TypeUserOffline    = "user_offline"
+	TypeLimitReject    = "limit_reject"
+	TypeRuleHit        = "rule_hit"
+	TypeQuotaExhausted = "quota_exhausted"
+	TypeNodeAdded      = "node_added"
+	TypeNodeRemoved    = "node_removed"
+	TypeOutboundDown   = "outbound_down"
+	TypeOutboundUp     = "outbound_up"
+)
+
+// DefaultBufferSize is the number of events kept by a Bus if the size is not set.
+const DefaultBufferSize = 1024
+
+// Event is something happened to a user or a node.
+type Event struct {
+	// Seq increases by one for every event of the Bus
+	Seq  uint64
+	Time time.Time
+	Type string
+	Node string
+	User string
+	Ip   string
+	// Detail is the reason of a reject, the rule and outbound of a rule hit,
+	// or the tag of an outbound going down or up
+	Detail string
+}
+
+// Bus keeps the latest events in a bounded buffer for polling,
+// and sends every event to the subscribers.
+// Events are dropped for a subscriber that does not keep up,
+// so a slow reader never blocks the connections.
+type Bus struct {
+	access sync.Mutex
+	ring   []Event
+	start  int
+	count  int
+	seq    uint64
+	subs   map[chan Event]struct{}
+}
+
+// NewBus creates a Bus keeping size events, DefaultBufferSize is used if size is zero.
+func NewBus(size int) *Bus {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	return &Bus{
+		ring: make([]Event, size),
+		subs: make(map[chan Event]struct{}),
+	}
+}
+
+// Publish records e, its Seq and Time are set by the Bus.
+// It does nothing on a nil Bus.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	b.access.Lock()
+	defer b.access.Unlock()
+	b.seq++
+	e.Seq = b.seq
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if b.count < len(b.ring) {
+		b.ring[(b.start+b.count)%len(b.ring)] = e
+		b.count++
+	} else {
+		// overwrite the oldest
+		b.ring[b.start] = e
+		b.start = (b.start + 1) % len(b.ring)
+	}
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Poll returns at most limit events after the event of Seq after, all if limit is zero.
+// lost reports whether some events after it have been overwritten.
+// An after beyond the latest event comes from a bus that is gone, such as
+// before a restart, so lost is reported and the events are polled from the oldest.
+func (b *Bus) Poll(after uint64, limit int) (events []Event, lost bool) {
+	b.access.Lock()
+	defer b.access.Unlock()
+	oldest := b.seq - uint64(b.count) + 1
+	if after > b.seq || after+1 < oldest {
+		lost = true
+		after = oldest - 1
+	}
+	n := int(b.seq - after)
+	if n <= 0 {
+		return []Event{}, lost
+	}
+	if limit > 0 && n > limit {
+		n = limit
+	}
+	events = make([]Event, n)
+	first := b.count - int(b.seq-after)
+	for i := range events {
+		events[i] = b.ring[(b.start+first+i)%len(b.ring)]
+	}
+	return events, lost
+}
+
+// Last returns the Seq of the latest event.
+func (b *Bus) Last() uint64 {
+	b.access.Lock()
+	defer b.access.Unlock()
+	return b.seq
+}
+
+// Subscribe returns a channel receiving the events published from now on,
+// it has a buffer of size. The channel is closed by cancel.
+func (b *Bus) Subscribe(size int) (events <-chan Event, cancel func()) {
+	ch := make(chan Event, size)
+	b.access.Lock()
+	b.subs[ch] = struct{}{}
+	b.access.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.access.Lock()
+			delete(b.subs, ch)
+			b.access.Unlock()
+			close(ch)
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package event
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBus_Poll(t *testing.T) {
+	b := NewBus(4)
+	if es, lost := b.Poll(0, 0); len(es) != 0 || lost {
+		t.Fatalf("Poll() of empty bus = %v %v", es, lost)
+	}
+	for i := 0; i < 3; i++ {
+		b.Publish(Event{Type: TypeUserOnline})
+	}
+	es, lost := b.Poll(1, 0)
+	if lost || len(es) != 2 || es[0].Seq != 2 || es[1].Seq != 3 {
+		t.Fatalf("Poll(1) = %v %v", es, lost)
+	}
+	if es, _ = b.Poll(0, 1); len(es) != 1 || es[0].Seq != 1 {
+		t.Fatalf("Poll(0, 1) = %v", es)
+	}
+	if es, _ = b.Poll(3, 0); len(es) != 0 {
+		t.Fatalf("Poll(3) = %v", es)
+	}
+	// overwrite the oldest
+	for i := 0; i < 3; i++ {
+		b.Publish(Event{Type: TypeUserOffline})
+	}
+	es, lost = b.Poll(1, 0)
+	if !lost || len(es) != 4 || es[0].Seq != 3 || es[3].Seq != 6 {
+		t.Fatalf("Poll(1) after overwrite = %v %v", es, lost)
+	}
+	if b.Last() != 6 {
+		t.Errorf("Last() = %d, want 6", b.Last())
+	}
+}
+
+func TestBus_Poll_Restart(t *testing.T) {
+	b := NewBus(4)
+	b.Publish(Event{Type: TypeUserOnline})
+	// the client polled up to 10 from the bus before a restart
+	es, lost := b.Poll(10, 0)
+	if !lost || len(es) != 1 || es[0].Seq != 1 {
+		t.Fatalf("Poll(10) = %v %v", es, lost)
+	}
+}
+
+func TestBus_Subscribe(t *testing.T) {
+	b := NewBus(0)
+	ch, cancel := b.Subscribe(1)
+	b.Publish(Event{Type: TypeNodeAdded, Node: "a"})
+	// dropped for the full subscriber, but kept for polling
+	b.Publish(Event{Type: TypeNodeRemoved, Node: "a"})
+	select {
+	case e := <-ch:
+		if e.Type != TypeNodeAdded || e.Seq != 1 {
+			t.Errorf("received %v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+	if es, _ := b.Poll(0, 0); len(es) != 2 {
+		t.Errorf("Poll() = %v, want 2 events", es)
+	}
+	cancel()
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Error("channel should be closed by cancel")
+	}
+	b.Publish(Event{Type: TypeNodeAdded})
+}
+
+func TestBus_Nil(t *testing.T) {
+	var b *Bus
+	b.Publish(Event{Type: TypeNodeAdded})
+}
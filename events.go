@@ -0,0 +1,36 @@
+package xray
+
+import (
+	"github.com/InazumaV/Ratte-Core-Xray/event"
+)
+
+type GetEventsParams struct {
+	// After is the Seq of the last event read, zero reads from the oldest kept
+	After uint64 `mapstructure:"After"`
+	// Limit is the most events returned, zero returns all
+	Limit int `mapstructure:"Limit"`
+}
+
+type GetEventsReply struct {
+	Events []event.Event
+	// Lost reports whether some events after After have been dropped from the buffer,
+	// or After is beyond the latest event, as after a restart of the core
+	Lost bool
+}
+
+// GetEvents returns the events after p.After, the Seq of the last
+// returned event should be passed as After of the next poll.
+func (c *Xray) GetEvents(p *GetEventsParams) *GetEventsReply {
+	es, lost := c.events.Poll(p.After, p.Limit)
+	return &GetEventsReply{
+		Events: es,
+		Lost:   lost,
+	}
+}
+
+// SubscribeEvents returns a channel receiving the events from now on,
+// events are dropped when its buffer of size is full.
+// It is for users embedding the core, cancel must be called once done.
+func (c *Xray) SubscribeEvents(size int) (events <-chan event.Event, cancel func()) {
+	return c.events.Subscribe(size)
+}
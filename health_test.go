@@ -0,0 +1,87 @@
+package xray
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/InazumaV/Ratte-Interface/core"
+	"github.com/InazumaV/Ratte-Interface/params"
+	"github.com/goccy/go-json"
+	coreConf "github.com/xtls/xray-core/infra/conf"
+)
+
+func TestXray_Observatory(t *testing.T) {
+	if _, _, err := buildCoreConfig(&XrayConfig{
+		Observatory:      &coreConf.ObservatoryConfig{},
+		BurstObservatory: &coreConf.BurstObservatoryConfig{},
+	}); err == nil {
+		t.Error("built both Observatory and BurstObservatory")
+	}
+	_, _, err := buildCoreConfig(&XrayConfig{Policy: AutoLoadRawMessage(`[]`)})
+	if errs, ok := err.(ConfigErrors); !ok || len(errs) != 1 || errs[0].Path != "Policy" {
+		t.Errorf("errors of a bad policy = %v", err)
+	}
+
+	probe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer probe.Close()
+	xr := NewXray()
+	err = xr.Start(t.TempDir(), []byte(fmt.Sprintf(`{
+	"Outbound": [{"tag": "good", "protocol": "freedom"}],
+	"Observatory": {
+		"subjectSelector": ["n1_out", "good"],
+		"probeURL": %q,
+		"probeInterval": "200ms",
+		"enableConcurrency": true
+	}
+}`, probe.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer xr.Close()
+	err = xr.AddNode(&core.AddNodeParams{
+		Name: "n1",
+		NodeInfo: &core.NodeInfo{
+			Type:  "vless",
+			Port:  freePort(t),
+			VLess: &params.VLess{VMess: params.VMess{Network: "tcp"}},
+			ExpandParams: params.ExpandParams{Options: map[string]any{
+				"SendIp": "127.0.0.1",
+				// a socks exit that is down
+				"RawOutbound": json.RawMessage(fmt.Sprintf(`{
+	"protocol": "socks",
+	"settings": {"servers": [{"address": "127.0.0.1", "port": %d}]}
+}`, freePort(t))),
+				"FailoverOutbounds": []string{"good"},
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		hs := xr.GetOutboundHealth()
+		if len(hs) == 2 && hs[0].Tag == "good" && hs[0].Alive && hs[1].Tag == "n1_out" && !hs[1].Alive {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("health = %+v", hs)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if tags, _ := xr.dispatcher.GetFailover("n1"); len(tags) != 1 || tags[0] != "good" {
+		t.Errorf("failover of n1 = %v", tags)
+	}
+	if err = xr.DelNode("n1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := xr.dispatcher.GetFailover("n1"); ok {
+		t.Error("failover of the deleted node is kept")
+	}
+}
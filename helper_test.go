@@ -0,0 +1,89 @@
+package xray
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/InazumaV/Ratte-Interface/core"
+	"github.com/InazumaV/Ratte-Interface/params"
+)
+
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// startTestNode starts a core with config and a vless node n1 with the user u1,
+// returns the core and the port of the node.
+func startTestNode(t *testing.T, dir string, config string) (*Xray, int) {
+	xr := NewXray()
+	if err := xr.Start(dir, []byte(config)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = xr.Close() })
+	port := freePort(t)
+	err := xr.AddNode(&core.AddNodeParams{
+		Name: "n1",
+		NodeInfo: &core.NodeInfo{
+			Type:  "vless",
+			Port:  port,
+			VLess: &params.VLess{VMess: params.VMess{Network: "tcp"}},
+			ExpandParams: params.ExpandParams{Options: map[string]any{
+				"SendIp": "127.0.0.1",
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = xr.AddUsers(&core.AddUsersParams{
+		NodeName: "n1",
+		Users: []core.UserInfo{
+			{Name: "u1", Key: []string{"a3482e88-686a-4a58-8126-99c9df64b7bf"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return xr, port
+}
+
+// echoServer starts a tcp server writing back what it reads, returns its port.
+func echoServer(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// waitFor fails the test if cond is not true in 5 seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -15,6 +15,7 @@ import (
 	_ "github.com/xtls/xray-core/app/commander"
 	_ "github.com/xtls/xray-core/app/log/command"
 	_ "github.com/xtls/xray-core/app/proxyman/command"
+	_ "github.com/xtls/xray-core/app/router/command"
 	_ "github.com/xtls/xray-core/app/stats/command"
 
 	// Developer preview services
@@ -34,7 +35,8 @@ import (
 	_ "github.com/xtls/xray-core/transport/internet/tagged/taggedimpl"
 
 	// Developer preview features
-	//_ "github.com/xtls/xray-core/app/observatory"
+	_ "github.com/xtls/xray-core/app/observatory"
+	_ "github.com/xtls/xray-core/app/observatory/burst"
 
 	// Inbound and outbound proxies.
 	_ "github.com/xtls/xray-core/proxy/blackhole"
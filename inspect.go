@@ -0,0 +1,131 @@
+package xray
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/InazumaV/Ratte-Core-Xray/common"
+	"github.com/InazumaV/Ratte-Core-Xray/dispatcher"
+	"github.com/InazumaV/Ratte-Interface/core"
+)
+
+type NodeStatus struct {
+	Name  string
+	Type  string
+	Port  int
+	Users int
+	// Conns is the number of live sessions on the node
+	Conns int
+}
+
+// ListNodes returns the nodes sorted by name.
+func (c *Xray) ListNodes() []NodeStatus {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	ns := make([]NodeStatus, 0, c.nodes.Count())
+	for name, n := range c.nodes.Items() {
+		ns = append(ns, NodeStatus{
+			Name:  name,
+			Type:  n.params.NodeInfo.Type,
+			Port:  n.params.NodeInfo.Port,
+			Users: n.users.Count(),
+			Conns: len(c.dispatcher.ListConns(name, "")),
+		})
+	}
+	sort.Slice(ns, func(i, j int) bool { return ns[i].Name < ns[j].Name })
+	return ns
+}
+
+type ListUsersParams struct {
+	NodeName string `mapstructure:"NodeName"`
+}
+
+type UserStatus struct {
+	Name string
+	// Up and Down are the traffic not reset yet
+	Up    int64
+	Down  int64
+	Conns int
+	Ips   []string
+}
+
+// ListUsers returns the users of the node sorted by name.
+func (c *Xray) ListUsers(p *ListUsersParams) ([]UserStatus, error) {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	n, ok := c.nodes.Get(p.NodeName)
+	if !ok {
+		return nil, fmt.Errorf("no such node: %s", p.NodeName)
+	}
+	us := make([]UserStatus, 0, n.users.Count())
+	for name := range n.users.Items() {
+		t := c.getUserTraffic(&core.GetUserTrafficParams{
+			NodeName: p.NodeName,
+			Username: name,
+		})
+		email := common.FormatUserEmail(p.NodeName, name)
+		ips, err := n.limiter.GetOnlineIps(email)
+		if err != nil {
+			return nil, fmt.Errorf("get online ips of %s error: %w", name, err)
+		}
+		us = append(us, UserStatus{
+			Name:  name,
+			Up:    t.Up,
+			Down:  t.Down,
+			Conns: n.limiter.GetConnCount(email),
+			Ips:   ips,
+		})
+	}
+	sort.Slice(us, func(i, j int) bool { return us[i].Name < us[j].Name })
+	return us, nil
+}
+
+type GetOnlineParams struct {
+	NodeName string `mapstructure:"NodeName"`
+}
+
+type OnlineUser struct {
+	Name  string
+	Ips   []string
+	Conns int
+}
+
+// GetOnline returns the users with live sessions on the node sorted by name.
+func (c *Xray) GetOnline(p *GetOnlineParams) ([]OnlineUser, error) {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	if !c.nodes.Has(p.NodeName) {
+		return nil, fmt.Errorf("no such node: %s", p.NodeName)
+	}
+	users := make(map[string]*OnlineUser)
+	for _, ci := range c.dispatcher.ListConns(p.NodeName, "") {
+		name := ci.User
+		if _, u, ok := common.ParseUserEmail(ci.User); ok {
+			name = u
+		}
+		u, ok := users[name]
+		if !ok {
+			u = &OnlineUser{Name: name}
+			users[name] = u
+		}
+		u.Conns++
+		if !common.InSlice(u.Ips, ci.Source) {
+			u.Ips = append(u.Ips, ci.Source)
+		}
+	}
+	us := make([]OnlineUser, 0, len(users))
+	for _, u := range users {
+		sort.Strings(u.Ips)
+		us = append(us, *u)
+	}
+	sort.Slice(us, func(i, j int) bool { return us[i].Name < us[j].Name })
+	return us, nil
+}
+
+// GetOutboundHealth returns the health of the outbounds probed by the observatory sorted by tag,
+// it is empty if neither Observatory nor BurstObservatory is set.
+func (c *Xray) GetOutboundHealth() []dispatcher.OutboundHealth {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	return c.dispatcher.GetOutboundHealth()
+}
@@ -0,0 +1,221 @@
+package xray
+
+import (
+	"fmt"
+	"github.com/InazumaV/Ratte-Core-Xray/common"
+	"github.com/InazumaV/Ratte-Core-Xray/limiter"
+	"time"
+)
+
+func (c *Xray) getLimiter(nodeName string) (*limiter.Limiter, error) {
+	l, ok := c.dispatcher.GetLimiter(nodeName)
+	if !ok {
+		return nil, fmt.Errorf("no limiter for node: %s", nodeName)
+	}
+	return l, nil
+}
+
+type GetRejectsParams struct {
+	NodeName string `mapstructure:"NodeName"`
+}
+
+// GetRejects returns the number of connections rejected by the limiter of the node by reason.
+func (c *Xray) GetRejects(p *GetRejectsParams) (map[string]int64, error) {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	l, err := c.getLimiter(p.NodeName)
+	if err != nil {
+		return nil, err
+	}
+	return l.GetRejects(), nil
+}
+
+type GetRegionRejectsParams struct {
+	NodeName string `mapstructure:"NodeName"`
+}
+
+// GetRegionRejects returns the number of connections rejected by the region filter of the node by country.
+func (c *Xray) GetRegionRejects(p *GetRegionRejectsParams) (map[string]int64, error) {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	l, err := c.getLimiter(p.NodeName)
+	if err != nil {
+		return nil, err
+	}
+	return l.GetRegionRejects(), nil
+}
+
+type GetThrottledParams struct {
+	NodeName string `mapstructure:"NodeName"`
+}
+
+// GetThrottled returns the users of the node currently throttled by the fair-use policy.
+func (c *Xray) GetThrottled(p *GetThrottledParams) ([]limiter.ThrottleStatus, error) {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	l, err := c.getLimiter(p.NodeName)
+	if err != nil {
+		return nil, err
+	}
+	return l.GetThrottled(), nil
+}
+
+// BanParams bans Username, or Ip if Username is empty, on the node.
+type BanParams struct {
+	NodeName string `mapstructure:"NodeName"`
+	Username string `mapstructure:"Username"`
+	Ip       string `mapstructure:"Ip"`
+	// Duration of the ban in seconds
+	Duration int    `mapstructure:"Duration"`
+	Reason   string `mapstructure:"Reason"`
+}
+
+// Ban rejects the new connections of a user or an ip until the ban expires,
+// the live connections are interrupted.
+func (c *Xray) Ban(p *BanParams) error {
+	if p.Duration <= 0 {
+		return fmt.Errorf("invalid ban duration: %d", p.Duration)
+	}
+	c.access.RLock()
+	defer c.access.RUnlock()
+	l, err := c.getLimiter(p.NodeName)
+	if err != nil {
+		return err
+	}
+	d := time.Duration(p.Duration) * time.Second
+	switch {
+	case p.Username != "":
+		email := common.FormatUserEmail(p.NodeName, p.Username)
+		l.BanUser(email, d, p.Reason)
+		c.dispatcher.KickUser(p.NodeName, email)
+	case p.Ip != "":
+		l.BanIp(p.Ip, d, p.Reason)
+		c.dispatcher.KickIp(p.NodeName, p.Ip)
+	default:
+		return fmt.Errorf("username or ip is required")
+	}
+	return nil
+}
+
+type UnbanParams struct {
+	NodeName string `mapstructure:"NodeName"`
+	Username string `mapstructure:"Username"`
+	Ip       string `mapstructure:"Ip"`
+}
+
+// Unban removes a ban before it expires, and reports whether there was one.
+func (c *Xray) Unban(p *UnbanParams) (bool, error) {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	l, err := c.getLimiter(p.NodeName)
+	if err != nil {
+		return false, err
+	}
+	var email string
+	if p.Username != "" {
+		email = common.FormatUserEmail(p.NodeName, p.Username)
+	}
+	return l.Unban(email, p.Ip), nil
+}
+
+type GetBansParams struct {
+	NodeName string `mapstructure:"NodeName"`
+}
+
+func (c *Xray) GetBans(p *GetBansParams) ([]limiter.Ban, error) {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	l, err := c.getLimiter(p.NodeName)
+	if err != nil {
+		return nil, err
+	}
+	return l.GetBans(), nil
+}
+
+type SetSourceFilterParams struct {
+	NodeName string   `mapstructure:"NodeName"`
+	Allow    []string `mapstructure:"Allow"`
+	Deny     []string `mapstructure:"Deny"`
+}
+
+// SetSourceFilter replaces the client source allow and deny lists of the node.
+func (c *Xray) SetSourceFilter(p *SetSourceFilterParams) error {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	l, err := c.getLimiter(p.NodeName)
+	if err != nil {
+		return err
+	}
+	return l.UpdateSourceFilter(limiter.SourceFilter{
+		Allow: p.Allow,
+		Deny:  p.Deny,
+	})
+}
+
+type GetSourceFilterParams struct {
+	NodeName string `mapstructure:"NodeName"`
+}
+
+func (c *Xray) GetSourceFilter(p *GetSourceFilterParams) (limiter.SourceFilter, error) {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	l, err := c.getLimiter(p.NodeName)
+	if err != nil {
+		return limiter.SourceFilter{}, err
+	}
+	return l.GetSourceFilter(), nil
+}
+
+type SetNodeSpeedLimitParams struct {
+	NodeName string `mapstructure:"NodeName"`
+	// SpeedLimit is in bytes per second, zero removes the cap
+	SpeedLimit uint64 `mapstructure:"SpeedLimit"`
+}
+
+// SetNodeSpeedLimit changes the cap of the total speed of the node in each direction.
+func (c *Xray) SetNodeSpeedLimit(p *SetNodeSpeedLimitParams) error {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	l, err := c.getLimiter(p.NodeName)
+	if err != nil {
+		return err
+	}
+	l.SetNodeSpeedLimit(p.SpeedLimit)
+	return nil
+}
+
+type SetQuotaParams struct {
+	NodeName string `mapstructure:"NodeName"`
+	Username string `mapstructure:"Username"`
+	// Remaining is the traffic budget in bytes, a negative one removes the budget
+	Remaining int64 `mapstructure:"Remaining"`
+}
+
+// SetQuota sets the remaining traffic budget of a user, the user is
+// cut off by the core once the budget is used up.
+func (c *Xray) SetQuota(p *SetQuotaParams) error {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	l, err := c.getLimiter(p.NodeName)
+	if err != nil {
+		return err
+	}
+	l.SetQuota(common.FormatUserEmail(p.NodeName, p.Username), p.Remaining)
+	return nil
+}
+
+type GetQuotasParams struct {
+	NodeName string `mapstructure:"NodeName"`
+}
+
+// GetQuotas returns the remaining traffic budgets of the users of the node,
+// the users cut off by their budget are marked as exhausted.
+func (c *Xray) GetQuotas(p *GetQuotasParams) ([]limiter.QuotaStatus, error) {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	l, err := c.getLimiter(p.NodeName)
+	if err != nil {
+		return nil, err
+	}
+	return l.GetQuotas(), nil
+}
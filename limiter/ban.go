@@ -0,0 +1,84 @@
+package limiter
+
+import (
+	"time"
+)
+
+// Ban rejects the connections of a user or from an ip until it expires.
+type Ban struct {
+	// User is the email of the banned user, empty for an ip ban
+	User   string
+	Ip     string
+	Reason string
+	Until  time.Time
+}
+
+func banKey(email, ip string) string {
+	if email != "" {
+		return "user:" + email
+	}
+	return "ip:" + ip
+}
+
+// BanUser rejects the connections of the user for d.
+func (l *Limiter) BanUser(email string, d time.Duration, reason string) {
+	l.bans.Set(banKey(email, ""), &Ban{
+		User:   email,
+		Reason: reason,
+		Until:  time.Now().Add(d),
+	})
+}
+
+// BanIp rejects the connections from ip for d.
+func (l *Limiter) BanIp(ip string, d time.Duration, reason string) {
+	l.bans.Set(banKey("", ip), &Ban{
+		Ip:     ip,
+		Reason: reason,
+		Until:  time.Now().Add(d),
+	})
+}
+
+// Unban removes the ban of the user, or of the ip if email is empty.
+// It reports whether there was a ban.
+func (l *Limiter) Unban(email, ip string) bool {
+	_, ok := l.bans.Pop(banKey(email, ip))
+	return ok
+}
+
+func (l *Limiter) getBan(key string, now time.Time) *Ban {
+	b, ok := l.bans.Get(key)
+	if !ok {
+		return nil
+	}
+	if now.After(b.Until) {
+		l.bans.RemoveCb(key, func(_ string, v *Ban, exists bool) bool {
+			return exists && v == b
+		})
+		return nil
+	}
+	return b
+}
+
+// CheckBan returns the ban of the user or the ip, nil if neither is banned.
+func (l *Limiter) CheckBan(email, ip string) *Ban {
+	if l.bans.IsEmpty() {
+		return nil
+	}
+	now := time.Now()
+	if b := l.getBan(banKey(email, ""), now); b != nil {
+		return b
+	}
+	return l.getBan(banKey("", ip), now)
+}
+
+// GetBans returns the bans not expired yet.
+func (l *Limiter) GetBans() []Ban {
+	now := time.Now()
+	bans := make([]Ban, 0)
+	for _, key := range l.bans.Keys() {
+		if b := l.getBan(key, now); b != nil {
+			bans = append(bans, *b)
+		}
+	}
+	return bans
+}
@@ -0,0 +1,32 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter_CheckBan(t *testing.T) {
+	l := NewLimiter(0, 0, 0, nil, nil)
+	l.BanUser("[a](node)", time.Minute, "abuse")
+	l.BanIp("1.1.1.1", time.Minute, "scanner")
+	l.BanUser("[c](node)", -time.Minute, "expired")
+
+	if b := l.CheckBan("[a](node)", "2.2.2.2"); b == nil || b.Reason != "abuse" {
+		t.Fatal("banned user should be rejected")
+	}
+	if b := l.CheckBan("[b](node)", "1.1.1.1"); b == nil || b.Reason != "scanner" {
+		t.Fatal("banned ip should be rejected")
+	}
+	if l.CheckBan("[b](node)", "2.2.2.2") != nil {
+		t.Fatal("user not banned should be accepted")
+	}
+	if l.CheckBan("[c](node)", "2.2.2.2") != nil {
+		t.Fatal("expired ban should be ignored")
+	}
+	if bans := l.GetBans(); len(bans) != 2 {
+		t.Fatalf("want 2 bans, got %v", bans)
+	}
+	if !l.Unban("[a](node)", "") || l.CheckBan("[a](node)", "2.2.2.2") != nil {
+		t.Fatal("unbanned user should be accepted")
+	}
+}
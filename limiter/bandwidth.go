@@ -0,0 +1,62 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+
+	"github.com/xtls/xray-core/common/buf"
+	"golang.org/x/time/rate"
+)
+
+// Direction is the direction of the traffic of a connection.
+type Direction int
+
+const (
+	Uplink Direction = iota
+	Downlink
+)
+
+// SetNodeSpeedLimit caps the total speed of every user of the node in bytes
+// per second in each direction, zero removes the cap.
+// The new cap applies to the live connections of the node.
+func (l *Limiter) SetNodeSpeedLimit(speed uint64) {
+	limit, burst := l.rateLimitOf(speed)
+	l.nodeSpeed.Store(speed)
+	for _, rl := range l.nodeRate {
+		if limit == rate.Inf {
+			rl.SetLimit(limit)
+			rl.SetBurst(burst)
+		} else {
+			rl.SetBurst(burst)
+			rl.SetLimit(limit)
+		}
+	}
+}
+
+func (l *Limiter) GetNodeSpeedLimit() uint64 {
+	return l.nodeSpeed.Load()
+}
+
+// getUserTurn returns the turns of the user at the rate of the node, one for each direction.
+func (l *Limiter) getUserTurn(email string) *[2]sync.Mutex {
+	return l.userTurn.Upsert(email, nil, func(exist bool, v *[2]sync.Mutex, _ *[2]sync.Mutex) *[2]sync.Mutex {
+		if exist {
+			return v
+		}
+		return new([2]sync.Mutex)
+	})
+}
+
+// NewWriter wraps writer of a connection of the user in the direction dir with
+// the speed limit of the user and the node.
+// The writer waits for the rate of the node even if it is not capped yet,
+// so a cap set later applies to it.
+func (l *Limiter) NewWriter(ctx context.Context, email string, dir Direction, writer buf.Writer) buf.Writer {
+	return &LimitedIoWriter{
+		ctx:     ctx,
+		writer:  writer,
+		limiter: l.GetRateLimiter(email),
+		node:    l.nodeRate[dir],
+		turn:    &l.getUserTurn(email)[dir],
+	}
+}
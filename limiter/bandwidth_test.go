@@ -0,0 +1,135 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+)
+
+func TestLimiter_NodeSpeedLimit_SetLater(t *testing.T) {
+	const speed = 1 << 20
+	l := NewLimiter(0, 0, 0, nil, nil)
+	l.Burst = MinBurst
+	ctx, cancel := context.WithTimeout(context.Background(), 600*time.Millisecond)
+	defer cancel()
+	// both directions of a connection made before the node is capped
+	sinks := []*sinkWriter{{}, {}}
+	ws := []buf.Writer{
+		l.NewWriter(ctx, "[a](node)", Uplink, sinks[0]),
+		l.NewWriter(ctx, "[a](node)", Downlink, sinks[1]),
+	}
+	l.SetNodeSpeedLimit(speed)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, w := range ws {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				mb, _ := newMultiBuffer(64 << 10)
+				if w.WriteMultiBuffer(mb) != nil {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	for i, sink := range sinks {
+		sent := float64(sink.n.Load())
+		if want := speed*elapsed.Seconds() + MinBurst; sent > want*1.1 || sent < want*0.8 {
+			t.Errorf("direction %d sent %v bytes in %v, want about %v", i, sent, elapsed, want)
+		}
+	}
+	l.SetNodeSpeedLimit(0)
+	if got := l.GetNodeSpeedLimit(); got != 0 {
+		t.Errorf("GetNodeSpeedLimit() = %d, want 0", got)
+	}
+}
+
+func TestLimiter_NodeSpeedLimit(t *testing.T) {
+	const speed = 1 << 20
+	l := NewLimiter(0, 0, 0, nil, nil)
+	l.Burst = MinBurst
+	l.SetNodeSpeedLimit(speed)
+	ctx, cancel := context.WithTimeout(context.Background(), 600*time.Millisecond)
+	defer cancel()
+	// user a opens four connections and user b opens one
+	users := map[string]int{"[a](node)": 4, "[b](node)": 1}
+	sinks := make(map[string][]*sinkWriter)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for email, conns := range users {
+		for i := 0; i < conns; i++ {
+			sink := &sinkWriter{}
+			sinks[email] = append(sinks[email], sink)
+			w := l.NewWriter(ctx, email, Uplink, sink)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					mb, _ := newMultiBuffer(64 << 10)
+					if w.WriteMultiBuffer(mb) != nil {
+						return
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	sent := make(map[string]float64)
+	var total float64
+	for email, ss := range sinks {
+		for _, s := range ss {
+			sent[email] += float64(s.n.Load())
+		}
+		total += sent[email]
+	}
+	// the burst is sent at once
+	if want := speed*elapsed.Seconds() + MinBurst; total > want*1.1 {
+		t.Errorf("node sent %v bytes in %v, want at most %v", total, elapsed, want)
+	}
+	if total < speed*elapsed.Seconds()*0.8 {
+		t.Errorf("node sent %v bytes in %v, want about %v", total, elapsed, speed*elapsed.Seconds())
+	}
+	a, b := sent["[a](node)"], sent["[b](node)"]
+	if b == 0 || a/b > 1.5 || b/a > 1.5 {
+		t.Errorf("users sent %v and %v bytes, want a fair share", a, b)
+	}
+}
+
+func TestLimiter_NodeSpeedLimit_UserLimit(t *testing.T) {
+	// the user limit is lower than its share of the node
+	const speed = 1 << 20
+	l := NewLimiter(0, 0, 0, nil, nil)
+	l.Burst = MinBurst
+	l.SetNodeSpeedLimit(speed)
+	ul := &UserLimit{SpeedLimit: speed / 4}
+	l.userLimit.Set("[a](node)", ul)
+	ctx, cancel := context.WithTimeout(context.Background(), 600*time.Millisecond)
+	defer cancel()
+	sinks := map[string]*sinkWriter{"[a](node)": {}, "[b](node)": {}}
+	var wg sync.WaitGroup
+	for email, sink := range sinks {
+		w := l.NewWriter(ctx, email, Uplink, sink)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				mb, _ := newMultiBuffer(64 << 10)
+				if w.WriteMultiBuffer(mb) != nil {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	a, b := float64(sinks["[a](node)"].n.Load()), float64(sinks["[b](node)"].n.Load())
+	if b < a*2 {
+		t.Errorf("users sent %v and %v bytes, want the unused share taken by b", a, b)
+	}
+}
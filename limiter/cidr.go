@@ -0,0 +1,104 @@
+package limiter
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+type cidrNode struct {
+	children [2]*cidrNode
+	end      bool
+}
+
+// CidrSet is a set of ip prefixes kept in a binary radix tree,
+// so a lookup costs at most one step per bit of the address.
+type CidrSet struct {
+	v4 *cidrNode
+	v6 *cidrNode
+}
+
+// NewCidrSet creates a CidrSet from prefixes like "10.0.0.0/8" or single ips.
+func NewCidrSet(cidrs []string) (*CidrSet, error) {
+	s := &CidrSet{
+		v4: &cidrNode{},
+		v6: &cidrNode{},
+	}
+	for _, c := range cidrs {
+		p, err := parsePrefix(c)
+		if err != nil {
+			return nil, err
+		}
+		s.Add(p)
+	}
+	return s, nil
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid cidr %s: %w", s, err)
+		}
+		if p.Addr().Is4In6() {
+			// a shorter one also covers addresses that are not ipv4
+			if p.Bits() < 96 {
+				return netip.Prefix{}, fmt.Errorf("invalid cidr %s: ipv4-mapped prefix shorter than /96", s)
+			}
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		return p.Masked(), nil
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid ip %s: %w", s, err)
+	}
+	a = a.Unmap()
+	return netip.PrefixFrom(a, a.BitLen()), nil
+}
+
+func bitAt(b []byte, i int) int {
+	return int(b[i/8]>>(7-uint(i%8))) & 1
+}
+
+func (s *CidrSet) Add(p netip.Prefix) {
+	n := s.v6
+	if p.Addr().Is4() {
+		n = s.v4
+	}
+	b := p.Addr().AsSlice()
+	for i := 0; i < p.Bits(); i++ {
+		if n.end {
+			// already covered by a shorter prefix
+			return
+		}
+		bit := bitAt(b, i)
+		if n.children[bit] == nil {
+			n.children[bit] = &cidrNode{}
+		}
+		n = n.children[bit]
+	}
+	n.end = true
+	// longer prefixes are covered now
+	n.children = [2]*cidrNode{}
+}
+
+func (s *CidrSet) Contains(a netip.Addr) bool {
+	a = a.Unmap()
+	n := s.v6
+	if a.Is4() {
+		n = s.v4
+	}
+	b := a.AsSlice()
+	for i := 0; n != nil; i++ {
+		if n.end {
+			return true
+		}
+		if i == len(b)*8 {
+			return false
+		}
+		n = n.children[bitAt(b, i)]
+	}
+	return false
+}
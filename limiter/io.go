@@ -2,18 +2,38 @@ package limiter
 
 import (
 	"context"
+	"sync"
+
 	"github.com/xtls/xray-core/common"
 	"github.com/xtls/xray-core/common/buf"
 	"golang.org/x/time/rate"
 )
 
+// writeChunk is the most bytes a connection sends in one turn, every connection
+// of a user sends the same bytes per turn no matter how large its buffers are,
+// and a small turn lets them take turns often.
+const writeChunk = buf.Size
+
+// LimitedIoWriter writes to writer at the rate of limiter, which may be shared by
+// every connection of a user.
+// A multi buffer is sent in chunks, and each chunk waits for its turn
+// behind the chunks of the other connections that asked earlier,
+// so the connections share the rate fairly.
+// If node is not nil the chunk then waits for the rate of the node, where
+// the user holds turn so it never has more than one chunk in the queue,
+// and the users share the rate of the node fairly.
 type LimitedIoWriter struct {
+	ctx     context.Context
 	writer  buf.Writer
 	limiter *rate.Limiter
+	node    *rate.Limiter
+	turn    *sync.Mutex
 }
 
-func NewRateLimitWriter(writer buf.Writer, limiter *rate.Limiter) buf.Writer {
+// NewRateLimitWriter creates a LimitedIoWriter, writes fail once ctx is done.
+func NewRateLimitWriter(ctx context.Context, writer buf.Writer, limiter *rate.Limiter) buf.Writer {
 	return &LimitedIoWriter{
+		ctx:     ctx,
 		writer:  writer,
 		limiter: limiter,
 	}
@@ -23,7 +43,58 @@ func (w *LimitedIoWriter) Close() error {
 	return common.Close(w.writer)
 }
 
+func (w *LimitedIoWriter) Interrupt() {
+	common.Interrupt(w.writer)
+}
+
+func limited(rl *rate.Limiter) bool {
+	return rl != nil && rl.Limit() != rate.Inf
+}
+
+// waitN waits until n bytes may be sent, in steps no larger than the burst.
+func waitN(ctx context.Context, rl *rate.Limiter, n int) error {
+	for n > 0 {
+		if !limited(rl) {
+			return nil
+		}
+		k := min(n, rl.Burst())
+		if err := rl.WaitN(ctx, k); err != nil {
+			return err
+		}
+		n -= k
+	}
+	return nil
+}
+
+func (w *LimitedIoWriter) wait(n int) error {
+	if err := waitN(w.ctx, w.limiter, n); err != nil {
+		return err
+	}
+	if !limited(w.node) {
+		return nil
+	}
+	w.turn.Lock()
+	defer w.turn.Unlock()
+	return waitN(w.ctx, w.node, n)
+}
+
 func (w *LimitedIoWriter) WriteMultiBuffer(mb buf.MultiBuffer) error {
-	_ = w.limiter.WaitN(context.Background(), int(mb.Len()))
-	return w.writer.WriteMultiBuffer(mb)
+	for !mb.IsEmpty() {
+		var chunk buf.MultiBuffer
+		if !limited(w.limiter) && !limited(w.node) {
+			chunk, mb = mb, nil
+		} else {
+			mb, chunk = buf.SplitSize(mb, writeChunk)
+		}
+		if err := w.wait(int(chunk.Len())); err != nil {
+			buf.ReleaseMulti(chunk)
+			buf.ReleaseMulti(mb)
+			return err
+		}
+		if err := w.writer.WriteMultiBuffer(chunk); err != nil {
+			buf.ReleaseMulti(mb)
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,149 @@
+package limiter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+)
+
+type sinkWriter struct {
+	n    atomic.Int64
+	data bytes.Buffer
+	keep bool
+}
+
+func (w *sinkWriter) WriteMultiBuffer(mb buf.MultiBuffer) error {
+	w.n.Add(int64(mb.Len()))
+	if w.keep {
+		for _, b := range mb {
+			w.data.Write(b.Bytes())
+		}
+	}
+	buf.ReleaseMulti(mb)
+	return nil
+}
+
+// newMultiBuffer returns n bytes of a counting pattern in full buffers.
+func newMultiBuffer(n int) (buf.MultiBuffer, []byte) {
+	var mb buf.MultiBuffer
+	raw := make([]byte, n)
+	for i := range raw {
+		raw[i] = byte(i)
+	}
+	for p := raw; len(p) > 0; {
+		b := buf.New()
+		k, _ := b.Write(p)
+		p = p[k:]
+		mb = append(mb, b)
+	}
+	return mb, raw
+}
+
+func newTestWriter(ctx context.Context, speed uint64, sink buf.Writer) buf.Writer {
+	l := NewLimiter(0, 0, speed, nil, nil)
+	l.Burst = MinBurst
+	return NewRateLimitWriter(ctx, sink, l.GetRateLimiter("[a](node)"))
+}
+
+func TestLimitedIoWriter_Throughput(t *testing.T) {
+	for _, speed := range []uint64{512 << 10, 2 << 20} {
+		sink := &sinkWriter{}
+		w := newTestWriter(context.Background(), speed, sink)
+		// far larger than the burst
+		total := int(speed / 2)
+		mb, _ := newMultiBuffer(total)
+		start := time.Now()
+		if err := w.WriteMultiBuffer(mb); err != nil {
+			t.Fatal(err)
+		}
+		elapsed := time.Since(start)
+		if got := sink.n.Load(); got != int64(total) {
+			t.Fatalf("written %d bytes, want %d", got, total)
+		}
+		// the burst is sent at once
+		want := time.Duration(float64(total-MinBurst) / float64(speed) * float64(time.Second))
+		if elapsed < want*8/10 || elapsed > want*13/10 {
+			t.Errorf("wrote %d bytes at %d B/s in %v, want about %v", total, speed, elapsed, want)
+		}
+	}
+}
+
+func TestLimitedIoWriter_Split(t *testing.T) {
+	sink := &sinkWriter{keep: true}
+	w := newTestWriter(context.Background(), 64<<20, sink)
+	mb, raw := newMultiBuffer(10*MinBurst + 100)
+	if err := w.WriteMultiBuffer(mb); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sink.data.Bytes(), raw) {
+		t.Error("written bytes differ from the multi buffer")
+	}
+}
+
+func TestLimitedIoWriter_Unlimited(t *testing.T) {
+	l := NewLimiter(0, 0, 0, nil, nil)
+	l.Throttle = &ThrottleConfig{}
+	sink := &sinkWriter{}
+	w := NewRateLimitWriter(context.Background(), sink, l.GetRateLimiter("[a](node)"))
+	mb, _ := newMultiBuffer(8 << 20)
+	start := time.Now()
+	if err := w.WriteMultiBuffer(mb); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("unlimited write took %v", elapsed)
+	}
+}
+
+func TestLimitedIoWriter_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sink := &sinkWriter{}
+	w := newTestWriter(ctx, 64<<10, sink)
+	mb, _ := newMultiBuffer(1 << 20)
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	err := w.WriteMultiBuffer(mb)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("write should fail once the context is done, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("write returned %v after cancel", elapsed)
+	}
+}
+
+func TestLimitedIoWriter_Fair(t *testing.T) {
+	const speed = 1 << 20
+	l := NewLimiter(0, 0, speed, nil, nil)
+	l.Burst = MinBurst
+	rl := l.GetRateLimiter("[a](node)")
+	ctx, cancel := context.WithTimeout(context.Background(), 600*time.Millisecond)
+	defer cancel()
+	// a connection writing huge buffers and one writing small buffers
+	sinks := []*sinkWriter{{}, {}}
+	sizes := []int{1 << 20, buf.Size}
+	var wg sync.WaitGroup
+	for i := range sinks {
+		w := NewRateLimitWriter(ctx, sinks[i], rl)
+		wg.Add(1)
+		go func(size int) {
+			defer wg.Done()
+			for {
+				mb, _ := newMultiBuffer(size)
+				if w.WriteMultiBuffer(mb) != nil {
+					return
+				}
+			}
+		}(sizes[i])
+	}
+	wg.Wait()
+	a, b := float64(sinks[0].n.Load()), float64(sinks[1].n.Load())
+	if b == 0 || a/b > 1.5 || b/a > 1.5 {
+		t.Errorf("connections sent %v and %v bytes, want a fair share", a, b)
+	}
+}
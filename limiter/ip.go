@@ -0,0 +1,202 @@
+package limiter
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cmap "github.com/orcaman/concurrent-map/v2"
+)
+
+// DefaultIpTTL is how long an ip stays online after its last connection ends.
+const DefaultIpTTL = time.Minute
+
+// IpStore records the online ips of users, it can be shared by
+// the limiters of several nodes or servers.
+type IpStore interface {
+	// CheckThenRecord reports whether ip would exceed limit for key, and records it if not.
+	// A limit of zero or less means no limit.
+	CheckThenRecord(key, ip string, limit int) (reject bool, err error)
+	// Release marks one connection of key from ip as closed,
+	// the ip expires after the ttl of the store if no connection is left.
+	Release(key, ip string) error
+	// List returns the online ips of key.
+	List(key string) ([]string, error)
+	Remove(key string) error
+	Close() error
+}
+
+type ipEntry struct {
+	conns    int
+	lastSeen time.Time
+}
+
+// ipList records the source ips a user is online with.
+type ipList struct {
+	access sync.Mutex
+	ttl    time.Duration
+	ips    map[string]*ipEntry
+}
+
+func newIpList(ttl time.Duration) *ipList {
+	return &ipList{
+		ttl: ttl,
+		ips: make(map[string]*ipEntry),
+	}
+}
+
+// checkThenRecord reports whether ip would exceed limit, and records it if not.
+// An ip that is already online is never rejected.
+func (l *ipList) checkThenRecord(ip string, limit int, now time.Time) (reject bool) {
+	l.access.Lock()
+	defer l.access.Unlock()
+	l.removeExpired(now)
+	e, ok := l.ips[ip]
+	if !ok {
+		if limit > 0 && len(l.ips) >= limit {
+			return true
+		}
+		e = &ipEntry{}
+		l.ips[ip] = e
+	}
+	e.conns++
+	e.lastSeen = now
+	return false
+}
+
+// release marks one connection from ip as closed.
+func (l *ipList) release(ip string, now time.Time) {
+	l.access.Lock()
+	defer l.access.Unlock()
+	if e, ok := l.ips[ip]; ok {
+		if e.conns > 0 {
+			e.conns--
+		}
+		e.lastSeen = now
+	}
+}
+
+// expire removes the expired ips, and reports whether no ip is left.
+func (l *ipList) expire(now time.Time) (empty bool) {
+	l.access.Lock()
+	defer l.access.Unlock()
+	l.removeExpired(now)
+	return len(l.ips) == 0
+}
+
+func (l *ipList) removeExpired(now time.Time) {
+	for ip, e := range l.ips {
+		if e.conns == 0 && now.Sub(e.lastSeen) > l.ttl {
+			delete(l.ips, ip)
+		}
+	}
+}
+
+func (l *ipList) list() []string {
+	l.access.Lock()
+	defer l.access.Unlock()
+	l.removeExpired(time.Now())
+	ips := make([]string, 0, len(l.ips))
+	for ip := range l.ips {
+		ips = append(ips, ip)
+	}
+	return ips
+}
+
+// active returns the ips with live connections.
+func (l *ipList) active() []string {
+	l.access.Lock()
+	defer l.access.Unlock()
+	var ips []string
+	for ip, e := range l.ips {
+		if e.conns > 0 {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+var _ IpStore = (*MemoryIpStore)(nil)
+
+// MemoryIpStore is an IpStore kept in the memory of the process.
+type MemoryIpStore struct {
+	ttl   time.Duration
+	lists cmap.ConcurrentMap[string, *ipList]
+	// lastSweep is the time the lists were last swept in unix nanoseconds
+	lastSweep atomic.Int64
+}
+
+// NewMemoryIpStore creates a MemoryIpStore, DefaultIpTTL is used if ttl is zero.
+func NewMemoryIpStore(ttl time.Duration) *MemoryIpStore {
+	if ttl <= 0 {
+		ttl = DefaultIpTTL
+	}
+	return &MemoryIpStore{
+		ttl:   ttl,
+		lists: cmap.New[*ipList](),
+	}
+}
+
+func (s *MemoryIpStore) CheckThenRecord(key, ip string, limit int) (reject bool, err error) {
+	now := time.Now()
+	s.sweep(now)
+	// recorded under the lock of the map, so the list is never removed in between
+	s.lists.Upsert(key, nil, func(exist bool, v *ipList, _ *ipList) *ipList {
+		if !exist {
+			v = newIpList(s.ttl)
+		}
+		reject = v.checkThenRecord(ip, limit, now)
+		return v
+	})
+	return reject, nil
+}
+
+// sweep removes the lists left without any ip once they are expired,
+// it goes through every list at most once per ttl.
+func (s *MemoryIpStore) sweep(now time.Time) {
+	last := s.lastSweep.Load()
+	if now.UnixNano()-last < int64(s.ttl) || !s.lastSweep.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	for _, key := range s.lists.Keys() {
+		s.lists.RemoveCb(key, func(_ string, v *ipList, exists bool) bool {
+			return exists && v.expire(now)
+		})
+	}
+}
+
+func (s *MemoryIpStore) Release(key, ip string) error {
+	if list, ok := s.lists.Get(key); ok {
+		list.release(ip, time.Now())
+	}
+	return nil
+}
+
+func (s *MemoryIpStore) List(key string) (ips []string, err error) {
+	s.lists.RemoveCb(key, func(_ string, v *ipList, exists bool) bool {
+		if !exists {
+			return false
+		}
+		ips = v.list()
+		return len(ips) == 0
+	})
+	return ips, nil
+}
+
+func (s *MemoryIpStore) Remove(key string) error {
+	s.lists.Remove(key)
+	return nil
+}
+
+func (s *MemoryIpStore) Close() error {
+	return nil
+}
+
+// active calls f with every key and ip that has live connections.
+func (s *MemoryIpStore) active(f func(key, ip string)) {
+	for item := range s.lists.IterBuffered() {
+		for _, ip := range item.Val.active() {
+			f(item.Key, ip)
+		}
+	}
+}
@@ -1,101 +1,270 @@
 package limiter
 
 import (
+	"fmt"
 	"github.com/InazumaV/Ratte-Core-Xray/common"
 	"github.com/InazumaV/Ratte-Interface/params"
+	mapS "github.com/mitchellh/mapstructure"
 	cmap "github.com/orcaman/concurrent-map/v2"
 	"golang.org/x/time/rate"
 	"regexp"
 	"sync"
+	"sync/atomic"
 )
 
 type Limiter struct {
-	IpLimit    int
+	IpLimit   int
+	ConnLimit int
+	// SpeedLimit is in bytes per second
 	SpeedLimit uint64
-	userLimit  cmap.ConcurrentMap[string, *UserLimit]
-	userIpList cmap.ConcurrentMap[string, cmap.ConcurrentMap[string, struct{}]]
-	ruleLock   sync.RWMutex
-	RegexpRule []*regexp.Regexp
+	// Burst is the bytes a user may send at once above its speed limit,
+	// it is one second of the speed limit if zero and never less than MinBurst
+	Burst int
+	// Throttle lowers the speed of users by their recent traffic if not nil
+	Throttle *ThrottleConfig
+	// GlobalIpLimit counts the ips of a user on every node sharing the IpStore
+	GlobalIpLimit bool
+	userLimit     cmap.ConcurrentMap[string, *UserLimit]
+	userConns     cmap.ConcurrentMap[string, *atomic.Int64]
+	userRate      cmap.ConcurrentMap[string, *rate.Limiter]
+	userTraffic   cmap.ConcurrentMap[string, *userTraffic]
+	ips           IpStore
+	rejects       cmap.ConcurrentMap[string, *atomic.Int64]
+	bans          cmap.ConcurrentMap[string, *Ban]
+	sources       atomic.Pointer[sourceFilter]
+	regions       atomic.Pointer[regionFilter]
+	regionRejects cmap.ConcurrentMap[string, *atomic.Int64]
+	nodeSpeed     atomic.Uint64
+	nodeRate      [2]*rate.Limiter
+	userTurn      cmap.ConcurrentMap[string, *[2]sync.Mutex]
+	quotas        cmap.ConcurrentMap[string, *Quota]
+	ruleLock      sync.RWMutex
+	RegexpRule    []*regexp.Regexp
 }
 
+// UserLimit overrides the limits of the node for a user, zero means
+// the limit of the node is used.
 type UserLimit struct {
 	UID        int
-	IpLimit    int
-	SpeedLimit uint64
+	IpLimit    int    `mapstructure:"DeviceLimit"`
+	ConnLimit  int    `mapstructure:"ConnLimit"`
+	SpeedLimit uint64 `mapstructure:"SpeedLimit"`
+	// Quota is the remaining traffic budget in bytes, unlimited if nil
+	Quota *int64 `mapstructure:"Quota"`
 }
 
-func NewLimiter(ipLimit int, speedLimit uint64, rules []string) *Limiter {
+// NewLimiter creates a limiter, ips may be shared with other limiters
+// and a private one is created if it is nil.
+func NewLimiter(ipLimit, connLimit int, speedLimit uint64, rules []string, ips IpStore) *Limiter {
+	if ips == nil {
+		ips = NewMemoryIpStore(DefaultIpTTL)
+	}
 	l := &Limiter{
-		IpLimit:    ipLimit,
-		SpeedLimit: speedLimit,
-		userLimit:  cmap.ConcurrentMap[string, *UserLimit]{},
-		userIpList: cmap.ConcurrentMap[string, cmap.ConcurrentMap[string, struct{}]]{},
+		IpLimit:       ipLimit,
+		ConnLimit:     connLimit,
+		SpeedLimit:    speedLimit,
+		userLimit:     cmap.New[*UserLimit](),
+		userConns:     cmap.New[*atomic.Int64](),
+		userRate:      cmap.New[*rate.Limiter](),
+		userTraffic:   cmap.New[*userTraffic](),
+		ips:           ips,
+		rejects:       cmap.New[*atomic.Int64](),
+		bans:          cmap.New[*Ban](),
+		regionRejects: cmap.New[*atomic.Int64](),
+		nodeRate:      [2]*rate.Limiter{rate.NewLimiter(rate.Inf, 0), rate.NewLimiter(rate.Inf, 0)},
+		userTurn:      cmap.New[*[2]sync.Mutex](),
+		quotas:        cmap.New[*Quota](),
 	}
 	l.UpdateRule(rules)
 	return l
 }
 
-func (l *Limiter) AddUserInfos(us []params.UserInfo) {
+// AddUserInfos records the limits of users, which are read from
+// the options of the user by the keys of the node limit options.
+func (l *Limiter) AddUserInfos(nodeName string, us []params.UserInfo) error {
 	for _, u := range us {
-		l.userLimit.Set(u.Name, &UserLimit{
-			UID:        u.Id,
-			IpLimit:    0, // need impl for user limit
-			SpeedLimit: 0,
-		})
+		ul := &UserLimit{}
+		if len(u.Options) > 0 {
+			err := mapS.WeakDecode(u.Options, ul)
+			if err != nil {
+				return fmt.Errorf("decode limit options of user %s error: %w", u.Name, err)
+			}
+		}
+		ul.UID = u.Id
+		email := common.FormatUserEmail(nodeName, u.Name)
+		l.userLimit.Set(email, ul)
+		l.applySpeedLimit(email)
+		if ul.Quota != nil {
+			l.SetQuota(email, *ul.Quota)
+		}
 	}
+	return nil
 }
 
 func (l *Limiter) DelUsers(nodeName string, us []string) {
 	for _, u := range us {
-		l.userIpList.Remove(common.FormatUserEmail(nodeName, u))
+		email := common.FormatUserEmail(nodeName, u)
+		l.userLimit.Remove(email)
+		l.removeIdleConns(email)
+		l.userRate.Remove(email)
+		l.userTraffic.Remove(email)
+		l.userTurn.Remove(email)
+		l.quotas.Remove(email)
+	}
+	if l.GlobalIpLimit {
+		// the ips may belong to the same user on other nodes
+		return
+	}
+	for _, u := range us {
+		_ = l.ips.Remove(common.FormatUserEmail(nodeName, u))
 	}
 }
 
-func (l *Limiter) CheckIpLimitThenRecord(email string, ip string) (reject bool, err error) {
-	info, ok := l.userLimit.Get(email)
-	if !ok {
-		return false, err
+func (l *Limiter) getConnLimit(email string) int {
+	if info, ok := l.userLimit.Get(email); ok && info.ConnLimit > 0 {
+		return info.ConnLimit
 	}
-	list, ok := l.userIpList.Get(email)
+	return l.ConnLimit
+}
+
+// CheckConnLimitThenAdd reports whether a new connection of the user
+// exceeds the connection limit, the connection is counted if not.
+// Every accepted connection must be released by ReleaseConn when it ends.
+func (l *Limiter) CheckConnLimitThenAdd(email string) (reject bool) {
+	limit := int64(l.getConnLimit(email))
+	// counted under the lock of the map, so the counter is never removed in between
+	l.userConns.Upsert(email, nil, func(exist bool, v *atomic.Int64, _ *atomic.Int64) *atomic.Int64 {
+		if !exist {
+			v = new(atomic.Int64)
+		}
+		if limit > 0 && v.Load() >= limit {
+			reject = true
+		} else {
+			v.Add(1)
+		}
+		return v
+	})
+	return reject
+}
+
+func (l *Limiter) ReleaseConn(email string) {
+	c, ok := l.userConns.Get(email)
 	if !ok {
-		newList := cmap.ConcurrentMap[string, struct{}]{}
-		newList.Set(ip, struct{}{})
-		l.userIpList.Set(email, newList)
-		return false, nil
+		return
 	}
-	_, ok = list.Get(ip)
-	if !ok {
-		return false, nil
+	if c.Add(-1) == 0 && !l.userLimit.Has(email) {
+		// the last connection of a deleted user
+		l.removeIdleConns(email)
 	}
-	if list.Count() > selectBigger(info.IpLimit, l.IpLimit) {
-		return true, nil
+}
+
+// removeIdleConns removes the connection counter of the user if no connection is left,
+// a counter with live connections is kept for them to release it, even if the user is added again.
+func (l *Limiter) removeIdleConns(email string) {
+	l.userConns.RemoveCb(email, func(_ string, c *atomic.Int64, exists bool) bool {
+		return exists && c.Load() <= 0
+	})
+}
+
+// GetConnCount returns the number of live connections of the user.
+func (l *Limiter) GetConnCount(email string) int {
+	if c, ok := l.userConns.Get(email); ok {
+		return int(c.Load())
 	}
-	list.Set(ip, struct{}{})
-	return false, nil
+	return 0
 }
 
-func (l *Limiter) CheckIpByCount(email string, c int) bool {
-	info, ok := l.userLimit.Get(email)
-	if !ok {
-		return false
+func (l *Limiter) getIpLimit(email string) int {
+	if info, ok := l.userLimit.Get(email); ok && info.IpLimit > 0 {
+		return info.IpLimit
 	}
-	if c >= info.IpLimit {
-		return true
+	return l.IpLimit
+}
+
+// ipKey returns the identity the ips of email are recorded under.
+func (l *Limiter) ipKey(email string) string {
+	if l.GlobalIpLimit {
+		if _, name, ok := common.ParseUserEmail(email); ok {
+			return name
+		}
 	}
-	return false
+	return email
+}
+
+// CheckIpLimitThenRecord reports whether a connection of the user from ip
+// exceeds the device limit, the ip is recorded as online if not.
+// Every accepted connection must be released by ReleaseIp when it ends.
+// The result stays usable when err is not nil, the store falls back to
+// what it knows locally.
+func (l *Limiter) CheckIpLimitThenRecord(email string, ip string) (reject bool, err error) {
+	return l.ips.CheckThenRecord(l.ipKey(email), ip, l.getIpLimit(email))
+}
+
+func (l *Limiter) ReleaseIp(email string, ip string) error {
+	return l.ips.Release(l.ipKey(email), ip)
+}
+
+// GetOnlineIps returns the ips the user is online with.
+func (l *Limiter) GetOnlineIps(email string) ([]string, error) {
+	return l.ips.List(l.ipKey(email))
+}
+
+func (l *Limiter) getSpeedLimit(email string) uint64 {
+	speed := l.SpeedLimit
+	if info, ok := l.userLimit.Get(email); ok && info.SpeedLimit > 0 {
+		speed = info.SpeedLimit
+	}
+	if ts := l.getThrottleSpeed(email); ts > 0 && (speed == 0 || ts < speed) {
+		speed = ts
+	}
+	return speed
+}
+
+// MinBurst is the least burst of a rate limiter, so a chunk of the writer always fits in.
+const MinBurst = writeChunk
+
+func (l *Limiter) rateLimitOf(speed uint64) (rate.Limit, int) {
+	if speed == 0 {
+		return rate.Inf, 0
+	}
+	burst := l.Burst
+	if burst <= 0 {
+		burst = int(speed)
+	}
+	return rate.Limit(speed), max(burst, MinBurst)
+}
+
+// GetRateLimiter returns the rate limiter shared by every connection of the user,
+// it is nil if the user is neither speed limited nor may be throttled.
+func (l *Limiter) GetRateLimiter(email string) *rate.Limiter {
+	speed := l.getSpeedLimit(email)
+	if speed == 0 && l.Throttle == nil {
+		return nil
+	}
+	return l.userRate.Upsert(email, nil, func(exist bool, v *rate.Limiter, _ *rate.Limiter) *rate.Limiter {
+		if exist {
+			return v
+		}
+		return rate.NewLimiter(l.rateLimitOf(speed))
+	})
 }
 
-func (l *Limiter) CheckSpeedLimitTheGetRateLimiter(email string) (limiter *rate.Limiter, err error) {
-	info, ok := l.userLimit.Get(email)
+// applySpeedLimit updates the shared rate limiter of the user,
+// so the new speed applies to its live connections.
+func (l *Limiter) applySpeedLimit(email string) {
+	rl, ok := l.userRate.Get(email)
 	if !ok {
-		return nil, err
+		return
 	}
-	sl := selectBigger(info.IpLimit, l.IpLimit)
-	if sl == 0 {
-		return nil, nil
+	limit, burst := l.rateLimitOf(l.getSpeedLimit(email))
+	// a finite limit must never meet a zero burst, which rejects every wait
+	if limit == rate.Inf {
+		rl.SetLimit(limit)
+		rl.SetBurst(burst)
+	} else {
+		rl.SetBurst(burst)
+		rl.SetLimit(limit)
 	}
-	li := rate.NewLimiter(rate.Limit(sl), sl)
-	return li, nil
 }
 
 func (l *Limiter) CheckRule(contents ...string) (reject bool) {
@@ -0,0 +1,171 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/InazumaV/Ratte-Interface/params"
+)
+
+func TestLimiter_CheckIpLimitThenRecord(t *testing.T) {
+	l := NewLimiter(2, 0, 0, nil, nil)
+	email := "[a](node)"
+	// new ips under the limit
+	if reject, _ := l.CheckIpLimitThenRecord(email, "1.1.1.1"); reject {
+		t.Fatal("first ip should be accepted")
+	}
+	if reject, _ := l.CheckIpLimitThenRecord(email, "2.2.2.2"); reject {
+		t.Fatal("second ip should be accepted")
+	}
+	// returning ip at the limit
+	if reject, _ := l.CheckIpLimitThenRecord(email, "1.1.1.1"); reject {
+		t.Fatal("online ip should be accepted")
+	}
+	// new ip over the limit
+	if reject, _ := l.CheckIpLimitThenRecord(email, "3.3.3.3"); !reject {
+		t.Fatal("third ip should be rejected")
+	}
+	// other users are not affected
+	if reject, _ := l.CheckIpLimitThenRecord("[b](node)", "3.3.3.3"); reject {
+		t.Fatal("ip of other user should be accepted")
+	}
+	if ips, _ := l.GetOnlineIps(email); len(ips) != 2 {
+		t.Fatalf("want 2 online ips, got %v", ips)
+	}
+}
+
+func TestLimiter_CheckIpLimitThenRecord_Unlimited(t *testing.T) {
+	l := NewLimiter(0, 0, 0, nil, nil)
+	for _, ip := range []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"} {
+		if reject, _ := l.CheckIpLimitThenRecord("[a](node)", ip); reject {
+			t.Fatalf("ip %s should be accepted without limit", ip)
+		}
+	}
+}
+
+func TestIpList_Expire(t *testing.T) {
+	l := newIpList(DefaultIpTTL)
+	now := time.Now()
+	if l.checkThenRecord("1.1.1.1", 1, now) {
+		t.Fatal("first ip should be accepted")
+	}
+	// still online while the connection is alive
+	if !l.checkThenRecord("2.2.2.2", 1, now.Add(2*DefaultIpTTL)) {
+		t.Fatal("ip with live connection should not expire")
+	}
+	l.release("1.1.1.1", now)
+	if !l.checkThenRecord("2.2.2.2", 1, now.Add(DefaultIpTTL/2)) {
+		t.Fatal("released ip should stay online until expired")
+	}
+	if l.checkThenRecord("2.2.2.2", 1, now.Add(2*DefaultIpTTL)) {
+		t.Fatal("expired ip should be removed")
+	}
+}
+
+func TestMemoryIpStore_RemoveEmpty(t *testing.T) {
+	s := NewMemoryIpStore(50 * time.Millisecond)
+	for _, key := range []string{"a", "b"} {
+		if reject, _ := s.CheckThenRecord(key, "1.1.1.1", 1); reject {
+			t.Fatal("first ip should be accepted")
+		}
+		_ = s.Release(key, "1.1.1.1")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if ips, _ := s.List("a"); len(ips) != 0 || s.lists.Has("a") {
+		t.Errorf("expired user a is kept with %v", ips)
+	}
+	if reject, _ := s.CheckThenRecord("c", "1.1.1.1", 1); reject {
+		t.Fatal("first ip should be accepted")
+	}
+	if s.lists.Has("b") {
+		t.Error("expired user b is kept")
+	}
+}
+
+func TestLimiter_GlobalIpLimit(t *testing.T) {
+	ips := NewMemoryIpStore(DefaultIpTTL)
+	l1 := NewLimiter(1, 0, 0, nil, ips)
+	l1.GlobalIpLimit = true
+	l2 := NewLimiter(1, 0, 0, nil, ips)
+	l2.GlobalIpLimit = true
+	if reject, _ := l1.CheckIpLimitThenRecord("[a](node1)", "1.1.1.1"); reject {
+		t.Fatal("first ip should be accepted")
+	}
+	if reject, _ := l2.CheckIpLimitThenRecord("[a](node2)", "1.1.1.1"); reject {
+		t.Fatal("same ip on other node should be accepted")
+	}
+	if reject, _ := l2.CheckIpLimitThenRecord("[a](node2)", "2.2.2.2"); !reject {
+		t.Fatal("new ip on other node should be rejected")
+	}
+	l3 := NewLimiter(1, 0, 0, nil, ips)
+	if reject, _ := l3.CheckIpLimitThenRecord("[a](node3)", "2.2.2.2"); reject {
+		t.Fatal("node without global limit should count its own ips")
+	}
+}
+
+func TestLimiter_CheckConnLimitThenAdd(t *testing.T) {
+	l := NewLimiter(0, 2, 0, nil, nil)
+	err := l.AddUserInfos("node", []params.UserInfo{
+		{Name: "b", ExpandParams: params.ExpandParams{Options: map[string]any{"ConnLimit": 3}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if l.CheckConnLimitThenAdd("[a](node)") {
+			t.Fatalf("connection %d should be accepted", i)
+		}
+	}
+	if !l.CheckConnLimitThenAdd("[a](node)") {
+		t.Fatal("connection over node limit should be rejected")
+	}
+	l.ReleaseConn("[a](node)")
+	if l.CheckConnLimitThenAdd("[a](node)") {
+		t.Fatal("connection after release should be accepted")
+	}
+	// user override
+	for i := 0; i < 3; i++ {
+		if l.CheckConnLimitThenAdd("[b](node)") {
+			t.Fatalf("connection %d of user with override should be accepted", i)
+		}
+	}
+	if !l.CheckConnLimitThenAdd("[b](node)") {
+		t.Fatal("connection over user limit should be rejected")
+	}
+}
+
+func TestLimiter_ReleaseConn_DeletedUser(t *testing.T) {
+	l := NewLimiter(0, 1, 0, nil, nil)
+	users := []params.UserInfo{{Name: "a"}}
+	if err := l.AddUserInfos("node", users); err != nil {
+		t.Fatal(err)
+	}
+	if l.CheckConnLimitThenAdd("[a](node)") {
+		t.Fatal("first connection should be accepted")
+	}
+	// the connection outlives the user, which is added again
+	l.DelUsers("node", []string{"a"})
+	if err := l.AddUserInfos("node", users); err != nil {
+		t.Fatal(err)
+	}
+	if !l.CheckConnLimitThenAdd("[a](node)") {
+		t.Fatal("connection over limit should be rejected while the old one is open")
+	}
+	l.ReleaseConn("[a](node)")
+	if n := l.GetConnCount("[a](node)"); n != 0 {
+		t.Fatalf("conns = %d after the old connection is released", n)
+	}
+	if l.CheckConnLimitThenAdd("[a](node)") {
+		t.Fatal("connection after release should be accepted")
+	}
+	if !l.CheckConnLimitThenAdd("[a](node)") {
+		t.Fatal("connection over limit should be rejected")
+	}
+
+	// the counter of a deleted user goes with its last connection
+	l.DelUsers("node", []string{"a"})
+	l.ReleaseConn("[a](node)")
+	if l.userConns.Has("[a](node)") {
+		t.Error("counter of a deleted user is kept without connections")
+	}
+}
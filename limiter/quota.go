@@ -0,0 +1,86 @@
+package limiter
+
+import (
+	"sync/atomic"
+)
+
+// Quota is the remaining traffic budget of a user in bytes,
+// it is shared by every connection of the user.
+type Quota struct {
+	remaining atomic.Int64
+}
+
+// Consume takes n bytes from the budget, it reports false and empties
+// the budget if less than n bytes remain.
+func (q *Quota) Consume(n int64) bool {
+	for {
+		r := q.remaining.Load()
+		if r < n {
+			if q.remaining.CompareAndSwap(r, 0) {
+				return false
+			}
+			continue
+		}
+		if q.remaining.CompareAndSwap(r, r-n) {
+			return true
+		}
+	}
+}
+
+func (q *Quota) Remaining() int64 {
+	return q.remaining.Load()
+}
+
+func (q *Quota) Exhausted() bool {
+	return q.remaining.Load() <= 0
+}
+
+// QuotaStatus is the remaining traffic budget of a user.
+type QuotaStatus struct {
+	User      string
+	Remaining int64
+	Exhausted bool
+}
+
+// SetQuota sets the remaining traffic budget of the user in bytes,
+// a negative remaining removes the budget.
+// The new budget applies to the live connections of the user,
+// which look up the budget on every write.
+func (l *Limiter) SetQuota(email string, remaining int64) {
+	if remaining < 0 {
+		l.quotas.Remove(email)
+		return
+	}
+	l.quotas.Upsert(email, nil, func(exist bool, v *Quota, _ *Quota) *Quota {
+		if exist {
+			return v
+		}
+		return &Quota{}
+	}).remaining.Store(remaining)
+}
+
+// GetQuota returns the traffic budget of the user, nil if it has none.
+func (l *Limiter) GetQuota(email string) *Quota {
+	q, _ := l.quotas.Get(email)
+	return q
+}
+
+// CheckQuota reports whether the user has used up its traffic budget.
+func (l *Limiter) CheckQuota(email string) (reject bool) {
+	q, ok := l.quotas.Get(email)
+	return ok && q.Exhausted()
+}
+
+// GetQuotas returns the traffic budgets of the users.
+func (l *Limiter) GetQuotas() []QuotaStatus {
+	qs := make([]QuotaStatus, 0, l.quotas.Count())
+	l.quotas.IterCb(func(email string, q *Quota) {
+		r := q.Remaining()
+		qs = append(qs, QuotaStatus{
+			User:      email,
+			Remaining: r,
+			Exhausted: r <= 0,
+		})
+	})
+	return qs
+}
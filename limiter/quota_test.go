@@ -0,0 +1,72 @@
+package limiter
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/InazumaV/Ratte-Interface/params"
+)
+
+func TestQuota_Consume(t *testing.T) {
+	l := NewLimiter(0, 0, 0, nil, nil)
+	email := "[a](node)"
+	if l.GetQuota(email) != nil || l.CheckQuota(email) {
+		t.Fatal("user without quota should be unlimited")
+	}
+	l.SetQuota(email, 1000)
+	q := l.GetQuota(email)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var consumed int64
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for q.Consume(7) {
+				mu.Lock()
+				consumed += 7
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if consumed > 1000 || consumed < 1000-7 {
+		t.Errorf("consumed %d bytes of a quota of 1000", consumed)
+	}
+	if !q.Exhausted() || !l.CheckQuota(email) {
+		t.Error("quota should be exhausted")
+	}
+	// renewed budget applies to the quota held by live connections
+	l.SetQuota(email, 10)
+	if !q.Consume(10) || q.Consume(1) {
+		t.Error("renewed quota should allow exactly its budget")
+	}
+	if qs := l.GetQuotas(); len(qs) != 1 || !qs[0].Exhausted || qs[0].User != email {
+		t.Errorf("GetQuotas() = %v", qs)
+	}
+	l.SetQuota(email, -1)
+	if l.CheckQuota(email) {
+		t.Error("removed quota should be unlimited")
+	}
+}
+
+func TestLimiter_AddUserInfos_Quota(t *testing.T) {
+	l := NewLimiter(0, 0, 0, nil, nil)
+	err := l.AddUserInfos("node", []params.UserInfo{
+		{Name: "a", ExpandParams: params.ExpandParams{Options: map[string]any{"Quota": "100"}}},
+		{Name: "b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := l.GetQuota("[a](node)"); q == nil || q.Remaining() != 100 {
+		t.Errorf("quota of a = %v, want 100", q)
+	}
+	if q := l.GetQuota("[b](node)"); q != nil {
+		t.Errorf("quota of b = %v, want none", q)
+	}
+	l.DelUsers("node", []string{"a"})
+	if q := l.GetQuota("[a](node)"); q != nil {
+		t.Error("quota of deleted user should be removed")
+	}
+}
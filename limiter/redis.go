@@ -0,0 +1,478 @@
+package limiter
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type RedisConfig struct {
+	Address  string `json:"Address"`
+	Password string `json:"Password"`
+	DB       int    `json:"DB"`
+	// Prefix is prepended to every key written by the store
+	Prefix string `json:"Prefix"`
+	// Timeout of dial and every command in seconds
+	Timeout int `json:"Timeout"`
+	// PoolSize is the max number of connections to the server, 4 if zero
+	PoolSize int `json:"PoolSize"`
+}
+
+const (
+	defaultRedisPoolSize = 4
+	// redisRetryMin and redisRetryMax bound the backoff before an unreachable server is tried again
+	redisRetryMin = time.Second
+	redisRetryMax = 30 * time.Second
+)
+
+// errRedisDown is returned without trying the server while it is backed off.
+var errRedisDown = errors.New("redis: server is unreachable, retry later")
+
+// errRedisClosed is returned once the client is closed.
+var errRedisClosed = errors.New("redis: client is closed")
+
+// redisClient is a minimal client of the redis protocol (RESP2),
+// it keeps a small pool of connections and reconnects on demand.
+// Once the server is unreachable, commands fail at once until the backoff is over,
+// then a single command tries the server again.
+type redisClient struct {
+	config  RedisConfig
+	timeout time.Duration
+	// conns holds one token for every connection allowed,
+	// an idle connection or nil if it is not open
+	conns    chan *redisConn
+	failures atomic.Int32
+	retryAt  atomic.Int64
+	// open holds every open connection, idle or busy, so Close can close them all
+	access sync.Mutex
+	open   map[*redisConn]struct{}
+	closed bool
+}
+
+func newRedisClient(c RedisConfig) *redisClient {
+	timeout := time.Duration(c.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 3 * time.Second
+	}
+	size := c.PoolSize
+	if size <= 0 {
+		size = defaultRedisPoolSize
+	}
+	conns := make(chan *redisConn, size)
+	for i := 0; i < size; i++ {
+		conns <- nil
+	}
+	return &redisClient{
+		config:  c,
+		timeout: timeout,
+		conns:   conns,
+		open:    make(map[*redisConn]struct{}),
+	}
+}
+
+type redisConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+}
+
+func (c *redisClient) connect() (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", c.config.Address, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: c.timeout,
+	}
+	if c.config.Password != "" {
+		if _, err = rc.exec("AUTH", c.config.Password); err != nil {
+			rc.close()
+			return nil, fmt.Errorf("auth error: %w", err)
+		}
+	}
+	if c.config.DB != 0 {
+		if _, err = rc.exec("SELECT", strconv.Itoa(c.config.DB)); err != nil {
+			rc.close()
+			return nil, fmt.Errorf("select db error: %w", err)
+		}
+	}
+	c.access.Lock()
+	defer c.access.Unlock()
+	if c.closed {
+		rc.close()
+		return nil, errRedisClosed
+	}
+	c.open[rc] = struct{}{}
+	return rc, nil
+}
+
+// drop closes a connection of the pool.
+func (c *redisClient) drop(rc *redisConn) {
+	c.access.Lock()
+	delete(c.open, rc)
+	c.access.Unlock()
+	rc.close()
+}
+
+func (c *redisClient) isClosed() bool {
+	c.access.Lock()
+	defer c.access.Unlock()
+	return c.closed
+}
+
+// available reports whether a command can be sent to the server,
+// only one command is let through when the backoff is over.
+func (c *redisClient) available() bool {
+	at := c.retryAt.Load()
+	if at == 0 {
+		return true
+	}
+	now := time.Now()
+	return now.UnixNano() >= at && c.retryAt.CompareAndSwap(at, now.Add(c.timeout*2).UnixNano())
+}
+
+// fail backs off the server, doubling the backoff on every failure in a row.
+func (c *redisClient) fail() {
+	backoff := redisRetryMax
+	if n := c.failures.Add(1); n <= 5 {
+		backoff = min(redisRetryMin<<(n-1), redisRetryMax)
+	}
+	c.retryAt.Store(time.Now().Add(backoff).UnixNano())
+}
+
+func (c *redisClient) succeed() {
+	if c.retryAt.Load() != 0 {
+		c.failures.Store(0)
+		c.retryAt.Store(0)
+	}
+}
+
+// Do sends a command and returns its reply, which is one of
+// string, int64, []any or nil.
+func (c *redisClient) Do(args ...string) (any, error) {
+	if c.isClosed() {
+		return nil, errRedisClosed
+	}
+	if !c.available() {
+		return nil, errRedisDown
+	}
+	var rc *redisConn
+	select {
+	case rc = <-c.conns:
+	default:
+		t := time.NewTimer(c.timeout)
+		select {
+		case rc = <-c.conns:
+			t.Stop()
+		case <-t.C:
+			return nil, errors.New("redis: no free connection")
+		}
+	}
+	if rc == nil {
+		var err error
+		if rc, err = c.connect(); err != nil {
+			c.conns <- nil
+			c.fail()
+			return nil, err
+		}
+	}
+	reply, err := rc.exec(args...)
+	var re redisError
+	if err != nil && !errors.As(err, &re) {
+		// the state of the connection is unknown
+		c.drop(rc)
+		c.conns <- nil
+		c.fail()
+		return nil, err
+	}
+	c.conns <- rc
+	c.succeed()
+	return reply, err
+}
+
+// redisScript is a lua script run by the server, it is sent once and then called by its sha1.
+type redisScript struct {
+	src string
+	sha string
+}
+
+func newRedisScript(src string) *redisScript {
+	sum := sha1.Sum([]byte(src))
+	return &redisScript{src: src, sha: hex.EncodeToString(sum[:])}
+}
+
+// Eval runs the script s with keys and args.
+func (c *redisClient) Eval(s *redisScript, keys []string, args ...string) (any, error) {
+	cmd := append([]string{"EVALSHA", s.sha, strconv.Itoa(len(keys))}, keys...)
+	cmd = append(cmd, args...)
+	reply, err := c.Do(cmd...)
+	var re redisError
+	if errors.As(err, &re) && strings.HasPrefix(string(re), "NOSCRIPT") {
+		cmd[0], cmd[1] = "EVAL", s.src
+		return c.Do(cmd...)
+	}
+	return reply, err
+}
+
+// Close closes every connection, the commands running on them fail.
+func (c *redisClient) Close() error {
+	c.access.Lock()
+	defer c.access.Unlock()
+	c.closed = true
+	for rc := range c.open {
+		rc.close()
+	}
+	clear(c.open)
+	return nil
+}
+
+func (rc *redisConn) close() {
+	_ = rc.conn.Close()
+}
+
+func (rc *redisConn) exec(args ...string) (any, error) {
+	if err := rc.conn.SetDeadline(time.Now().Add(rc.timeout)); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 0, 64)
+	b = append(b, '*')
+	b = strconv.AppendInt(b, int64(len(args)), 10)
+	b = append(b, '\r', '\n')
+	for _, a := range args {
+		b = append(b, '$')
+		b = strconv.AppendInt(b, int64(len(a)), 10)
+		b = append(b, '\r', '\n')
+		b = append(b, a...)
+		b = append(b, '\r', '\n')
+	}
+	if _, err := rc.conn.Write(b); err != nil {
+		return nil, err
+	}
+	return readRedisReply(rc.reader)
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+func readRedisLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errors.New("redis: invalid line")
+	}
+	return line[:len(line)-2], nil
+}
+
+func readRedisReply(r *bufio.Reader) (any, error) {
+	line, err := readRedisLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err = io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readRedisReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", line[0])
+	}
+}
+
+var _ IpStore = (*RedisIpStore)(nil)
+
+// RedisIpStore is an IpStore kept in a redis compatible server, so the
+// device limit can be enforced across servers.
+// Every user is a sorted set of ips scored by their expiry time.
+// Every operation is mirrored to a local MemoryIpStore, which is used
+// when the server is unreachable.
+type RedisIpStore struct {
+	client *redisClient
+	prefix string
+	ttl    time.Duration
+	local  *MemoryIpStore
+	done   chan struct{}
+	close  sync.Once
+}
+
+// NewRedisIpStore creates a RedisIpStore, DefaultIpTTL is used if ttl is zero.
+func NewRedisIpStore(c RedisConfig, ttl time.Duration) *RedisIpStore {
+	if ttl <= 0 {
+		ttl = DefaultIpTTL
+	}
+	s := &RedisIpStore{
+		client: newRedisClient(c),
+		prefix: c.Prefix,
+		ttl:    ttl,
+		local:  NewMemoryIpStore(ttl),
+		done:   make(chan struct{}),
+	}
+	go s.keepAlive()
+	return s
+}
+
+// keepAlive refreshes the expiry of ips with live connections,
+// since the server only knows when they were last seen.
+func (s *RedisIpStore) keepAlive() {
+	t := time.NewTicker(s.ttl / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C:
+			s.local.active(func(key, ip string) {
+				_ = s.refresh(key, ip)
+			})
+		}
+	}
+}
+
+func (s *RedisIpStore) key(key string) string {
+	return s.prefix + key
+}
+
+func (s *RedisIpStore) expireAt(now time.Time) string {
+	return strconv.FormatInt(now.Add(s.ttl).UnixMilli(), 10)
+}
+
+func (s *RedisIpStore) refresh(key, ip string) error {
+	k := s.key(key)
+	if _, err := s.client.Do("ZADD", k, s.expireAt(time.Now()), ip); err != nil {
+		return err
+	}
+	_, err := s.client.Do("PEXPIRE", k, strconv.FormatInt(s.ttl.Milliseconds(), 10))
+	return err
+}
+
+// checkThenRecordScript is checkThenRecord of ipList run by the server in one step,
+// so servers sharing the store can not admit more ips than the limit together.
+// KEYS[1] is the user, ARGV are the ip, the limit, the time now and the ttl in milliseconds.
+var checkThenRecordScript = newRedisScript(`
+local now = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+if not redis.call('ZSCORE', KEYS[1], ARGV[1]) then
+	local limit = tonumber(ARGV[2])
+	if limit > 0 and redis.call('ZCARD', KEYS[1]) >= limit then
+		return 1
+	end
+end
+redis.call('ZADD', KEYS[1], now + tonumber(ARGV[4]), ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return 0
+`)
+
+func (s *RedisIpStore) checkThenRecord(key, ip string, limit int) (reject bool, err error) {
+	reply, err := s.client.Eval(checkThenRecordScript, []string{s.key(key)},
+		ip,
+		strconv.Itoa(limit),
+		strconv.FormatInt(time.Now().UnixMilli(), 10),
+		strconv.FormatInt(s.ttl.Milliseconds(), 10),
+	)
+	if err != nil {
+		return false, err
+	}
+	return reply == int64(1), nil
+}
+
+func (s *RedisIpStore) CheckThenRecord(key, ip string, limit int) (reject bool, err error) {
+	reject, err = s.checkThenRecord(key, ip, limit)
+	if err != nil {
+		reject, _ = s.local.CheckThenRecord(key, ip, limit)
+		return reject, fmt.Errorf("redis unreachable, use local cache: %w", err)
+	}
+	if !reject {
+		// the server already decided, the local cache only follows
+		_, _ = s.local.CheckThenRecord(key, ip, 0)
+	}
+	return reject, nil
+}
+
+func (s *RedisIpStore) Release(key, ip string) error {
+	_ = s.local.Release(key, ip)
+	return s.refresh(key, ip)
+}
+
+func (s *RedisIpStore) List(key string) ([]string, error) {
+	k := s.key(key)
+	_, err := s.client.Do("ZREMRANGEBYSCORE", k, "-inf", strconv.FormatInt(time.Now().UnixMilli(), 10))
+	if err != nil {
+		ips, _ := s.local.List(key)
+		return ips, fmt.Errorf("redis unreachable, use local cache: %w", err)
+	}
+	reply, err := s.client.Do("ZRANGE", k, "0", "-1")
+	if err != nil {
+		ips, _ := s.local.List(key)
+		return ips, fmt.Errorf("redis unreachable, use local cache: %w", err)
+	}
+	items, _ := reply.([]any)
+	ips := make([]string, 0, len(items))
+	for _, item := range items {
+		if ip, ok := item.(string); ok {
+			ips = append(ips, ip)
+		}
+	}
+	return ips, nil
+}
+
+func (s *RedisIpStore) Remove(key string) error {
+	_ = s.local.Remove(key)
+	_, err := s.client.Do("DEL", s.key(key))
+	return err
+}
+
+func (s *RedisIpStore) Close() (err error) {
+	s.close.Do(func() {
+		close(s.done)
+		err = s.client.Close()
+	})
+	return err
+}
@@ -0,0 +1,304 @@
+package limiter
+
+import (
+	"bufio"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a redis compatible stand-in implementing the commands used by RedisIpStore.
+type fakeRedis struct {
+	access  sync.Mutex
+	l       net.Listener
+	zsets   map[string]map[string]int64
+	scripts map[string]bool
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{l: l, zsets: make(map[string]map[string]int64), scripts: make(map[string]bool)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		reply, err := readRedisReply(r)
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]any)
+		args := make([]string, len(items))
+		for i := range items {
+			args[i], _ = items[i].(string)
+		}
+		if _, err = conn.Write([]byte(f.exec(args))); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeRedis) exec(args []string) string {
+	f.access.Lock()
+	defer f.access.Unlock()
+	switch args[0] {
+	case "PING", "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "ZADD":
+		z, ok := f.zsets[args[1]]
+		if !ok {
+			z = make(map[string]int64)
+			f.zsets[args[1]] = z
+		}
+		score, _ := strconv.ParseInt(args[2], 10, 64)
+		_, exist := z[args[3]]
+		z[args[3]] = score
+		if exist {
+			return ":0\r\n"
+		}
+		return ":1\r\n"
+	case "ZSCORE":
+		score, ok := f.zsets[args[1]][args[2]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		s := strconv.FormatInt(score, 10)
+		return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+	case "ZCARD":
+		return ":" + strconv.Itoa(len(f.zsets[args[1]])) + "\r\n"
+	case "ZREMRANGEBYSCORE":
+		max, _ := strconv.ParseInt(args[3], 10, 64)
+		n := 0
+		for m, score := range f.zsets[args[1]] {
+			if score <= max {
+				delete(f.zsets[args[1]], m)
+				n++
+			}
+		}
+		return ":" + strconv.Itoa(n) + "\r\n"
+	case "ZRANGE":
+		var ms []string
+		for m := range f.zsets[args[1]] {
+			ms = append(ms, m)
+		}
+		sort.Strings(ms)
+		s := "*" + strconv.Itoa(len(ms)) + "\r\n"
+		for _, m := range ms {
+			s += "$" + strconv.Itoa(len(m)) + "\r\n" + m + "\r\n"
+		}
+		return s
+	case "DEL":
+		delete(f.zsets, args[1])
+		return ":1\r\n"
+	case "PEXPIRE":
+		return ":1\r\n"
+	case "EVAL":
+		sha := newRedisScript(args[1]).sha
+		if sha != checkThenRecordScript.sha {
+			return "-ERR unknown script\r\n"
+		}
+		f.scripts[sha] = true
+		return f.checkThenRecord(args[3], args[4:])
+	case "EVALSHA":
+		if !f.scripts[args[1]] {
+			return "-NOSCRIPT No matching script\r\n"
+		}
+		return f.checkThenRecord(args[3], args[4:])
+	default:
+		return "-ERR unknown command\r\n"
+	}
+}
+
+// checkThenRecord runs checkThenRecordScript.
+func (f *fakeRedis) checkThenRecord(key string, args []string) string {
+	ip := args[0]
+	limit, _ := strconv.Atoi(args[1])
+	now, _ := strconv.ParseInt(args[2], 10, 64)
+	ttl, _ := strconv.ParseInt(args[3], 10, 64)
+	z, ok := f.zsets[key]
+	if !ok {
+		z = make(map[string]int64)
+		f.zsets[key] = z
+	}
+	for m, score := range z {
+		if score <= now {
+			delete(z, m)
+		}
+	}
+	if _, ok = z[ip]; !ok && limit > 0 && len(z) >= limit {
+		return ":1\r\n"
+	}
+	z[ip] = now + ttl
+	return ":0\r\n"
+}
+
+func TestRedisIpStore(t *testing.T) {
+	f := newFakeRedis(t)
+	s1 := NewRedisIpStore(RedisConfig{Address: f.l.Addr().String(), Prefix: "ratte:"}, time.Minute)
+	defer s1.Close()
+	s2 := NewRedisIpStore(RedisConfig{Address: f.l.Addr().String(), Prefix: "ratte:"}, time.Minute)
+	defer s2.Close()
+
+	// the stores act like two servers sharing the same backend
+	if reject, err := s1.CheckThenRecord("a", "1.1.1.1", 2); reject || err != nil {
+		t.Fatalf("first ip should be accepted: %v", err)
+	}
+	if reject, err := s2.CheckThenRecord("a", "2.2.2.2", 2); reject || err != nil {
+		t.Fatalf("second ip should be accepted: %v", err)
+	}
+	if reject, err := s2.CheckThenRecord("a", "1.1.1.1", 2); reject || err != nil {
+		t.Fatalf("online ip should be accepted: %v", err)
+	}
+	if reject, err := s1.CheckThenRecord("a", "3.3.3.3", 2); !reject || err != nil {
+		t.Fatalf("third ip should be rejected: %v", err)
+	}
+	ips, err := s1.List("a")
+	if err != nil || len(ips) != 2 {
+		t.Fatalf("want 2 online ips, got %v: %v", ips, err)
+	}
+
+	// the local cache is used when the backend is unreachable
+	_ = f.l.Close()
+	_ = s1.client.Close()
+	reject, err := s1.CheckThenRecord("a", "1.1.1.1", 1)
+	if err == nil {
+		t.Fatal("unreachable backend should be reported")
+	}
+	if reject {
+		t.Fatal("ip in local cache should be accepted")
+	}
+	if reject, _ = s1.CheckThenRecord("a", "3.3.3.3", 1); !reject {
+		t.Fatal("ip over limit should be rejected by local cache")
+	}
+}
+
+func TestRedisIpStore_Expire(t *testing.T) {
+	f := newFakeRedis(t)
+	defer f.l.Close()
+	s := NewRedisIpStore(RedisConfig{Address: f.l.Addr().String()}, 50*time.Millisecond)
+	defer s.Close()
+	if reject, _ := s.CheckThenRecord("a", "1.1.1.1", 1); reject {
+		t.Fatal("first ip should be accepted")
+	}
+	_ = s.Release("a", "1.1.1.1")
+	_ = s.local.Remove("a")
+	time.Sleep(100 * time.Millisecond)
+	if reject, _ := s.CheckThenRecord("a", "2.2.2.2", 1); reject {
+		t.Fatal("expired ip should be removed")
+	}
+}
+
+func TestRedisIpStore_Concurrent(t *testing.T) {
+	f := newFakeRedis(t)
+	defer f.l.Close()
+	var (
+		wg       sync.WaitGroup
+		accepted atomic.Int32
+	)
+	for i := 0; i < 20; i++ {
+		s := NewRedisIpStore(RedisConfig{Address: f.l.Addr().String()}, time.Minute)
+		defer s.Close()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reject, err := s.CheckThenRecord("a", "1.1.1."+strconv.Itoa(i), 3)
+			if err != nil {
+				t.Error(err)
+			}
+			if !reject {
+				accepted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := accepted.Load(); n != 3 {
+		t.Errorf("%d ips accepted by servers sharing a limit of 3", n)
+	}
+}
+
+func TestRedisIpStore_Unreachable(t *testing.T) {
+	// a server accepting connections without ever replying
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	s := NewRedisIpStore(RedisConfig{Address: l.Addr().String(), Timeout: 1}, time.Minute)
+	if _, err = s.CheckThenRecord("a", "1.1.1.1", 1); err == nil {
+		t.Fatal("unreachable backend should be reported")
+	}
+	start := time.Now()
+	reject, err := s.CheckThenRecord("a", "2.2.2.2", 1)
+	if err == nil || !reject {
+		t.Fatalf("the local cache should reject the ip over limit: %v", err)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("waited %s for the backed off backend", d)
+	}
+	_ = s.Close()
+	_ = s.Close()
+}
+
+func TestRedisClient_Close(t *testing.T) {
+	// a server accepting connections without ever replying
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	c := newRedisClient(RedisConfig{Address: l.Addr().String(), Timeout: 10})
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Do("PING")
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	_ = c.Close()
+	select {
+	case err = <-done:
+		if err == nil {
+			t.Error("command on a closed connection should fail")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the busy connection is kept open after Close")
+	}
+	if _, err = c.Do("PING"); err != errRedisClosed {
+		t.Errorf("command after Close = %v", err)
+	}
+}
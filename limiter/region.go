@@ -0,0 +1,204 @@
+package limiter
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/xtls/xray-core/app/router"
+	"github.com/xtls/xray-core/common/platform/filesystem"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// RegionOther is the region counted for a source rejected for not being
+// in any allowed region, since only the listed regions are loaded.
+const RegionOther = "OTHER"
+
+// RegionFilter is the lists of client countries allowed and denied on a node,
+// every entry is a country code of geoip.dat like "cn".
+// A source in Deny is always rejected, and a source not in Allow is rejected
+// unless Allow is empty.
+// Only the listed countries are loaded, so a source rejected for not being
+// in Allow is counted as RegionOther instead of its real country.
+type RegionFilter struct {
+	Allow []string
+	Deny  []string
+}
+
+type region struct {
+	code  string
+	cidrs *CidrSet
+}
+
+type regionFilter struct {
+	raw   RegionFilter
+	allow []region
+	deny  []region
+}
+
+// LoadGeoIP loads the cidrs of the countries from geoip.dat of the asset path,
+// the file is read once for all of them. The map is keyed by the upper case
+// country codes, the codes not in the file are left out.
+func LoadGeoIP(codes ...string) (map[string]*CidrSet, error) {
+	want := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		want[strings.ToUpper(strings.TrimSpace(code))] = true
+	}
+	b, err := filesystem.ReadAsset("geoip.dat")
+	if err != nil {
+		return nil, fmt.Errorf("read geoip.dat error: %w", err)
+	}
+	sets := make(map[string]*CidrSet, len(want))
+	// only the entries of the wanted countries are decoded
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, fmt.Errorf("decode geoip.dat error: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		if num != 1 || typ != protowire.BytesType {
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return nil, fmt.Errorf("decode geoip.dat error: %w", protowire.ParseError(n))
+			}
+			b = b[n:]
+			continue
+		}
+		entry, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return nil, fmt.Errorf("decode geoip.dat error: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		code := strings.ToUpper(geoipCode(entry))
+		if !want[code] || sets[code] != nil {
+			continue
+		}
+		var g router.GeoIP
+		if err = proto.Unmarshal(entry, &g); err != nil {
+			return nil, fmt.Errorf("decode geoip %s error: %w", code, err)
+		}
+		if sets[code], err = newGeoIPSet(code, g.Cidr); err != nil {
+			return nil, err
+		}
+	}
+	return sets, nil
+}
+
+// geoipCode returns the country code of an encoded geoip entry.
+func geoipCode(entry []byte) string {
+	for len(entry) > 0 {
+		num, typ, n := protowire.ConsumeTag(entry)
+		if n < 0 {
+			return ""
+		}
+		entry = entry[n:]
+		if num == 1 && typ == protowire.BytesType {
+			v, _ := protowire.ConsumeBytes(entry)
+			return string(v)
+		}
+		if n = protowire.ConsumeFieldValue(num, typ, entry); n < 0 {
+			return ""
+		}
+		entry = entry[n:]
+	}
+	return ""
+}
+
+func newGeoIPSet(code string, cidrs []*router.CIDR) (*CidrSet, error) {
+	s, _ := NewCidrSet(nil)
+	for _, c := range cidrs {
+		a, ok := netip.AddrFromSlice(c.Ip)
+		if !ok {
+			return nil, fmt.Errorf("invalid ip of geoip %s: %v", code, c.Ip)
+		}
+		bits := int(c.Prefix)
+		if a.Is4In6() {
+			a, bits = a.Unmap(), bits-96
+		}
+		p, err := a.Prefix(bits)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr of geoip %s: %w", code, err)
+		}
+		s.Add(p)
+	}
+	return s, nil
+}
+
+func loadRegions(codes []string, sets map[string]*CidrSet) ([]region, error) {
+	rs := make([]region, 0, len(codes))
+	for _, code := range codes {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		s, ok := sets[code]
+		if !ok {
+			return nil, errors.New("code not found in geoip.dat: " + code)
+		}
+		rs = append(rs, region{code: code, cidrs: s})
+	}
+	return rs, nil
+}
+
+// UpdateRegionFilter replaces the region filter of the node,
+// the countries are loaded from geoip.dat.
+func (l *Limiter) UpdateRegionFilter(f RegionFilter) error {
+	var sets map[string]*CidrSet
+	if len(f.Allow) > 0 || len(f.Deny) > 0 {
+		var err error
+		sets, err = LoadGeoIP(append(append([]string(nil), f.Allow...), f.Deny...)...)
+		if err != nil {
+			return err
+		}
+	}
+	allow, err := loadRegions(f.Allow, sets)
+	if err != nil {
+		return err
+	}
+	deny, err := loadRegions(f.Deny, sets)
+	if err != nil {
+		return err
+	}
+	l.regions.Store(&regionFilter{
+		raw:   f,
+		allow: allow,
+		deny:  deny,
+	})
+	return nil
+}
+
+func (l *Limiter) GetRegionFilter() RegionFilter {
+	if f := l.regions.Load(); f != nil {
+		return f.raw
+	}
+	return RegionFilter{}
+}
+
+// CheckRegion reports whether connections from the source ip should be rejected
+// and the region it is rejected for, ipv6 addresses may be enclosed in brackets.
+// A source that is not an ip is rejected as RegionOther if Allow is not empty.
+func (l *Limiter) CheckRegion(ip string) (region string, reject bool) {
+	f := l.regions.Load()
+	if f == nil {
+		return "", false
+	}
+	a, ok := parseSourceIp(ip)
+	if !ok {
+		if len(f.allow) > 0 {
+			return RegionOther, true
+		}
+		return "", false
+	}
+	for _, r := range f.deny {
+		if r.cidrs.Contains(a) {
+			return r.code, true
+		}
+	}
+	if len(f.allow) == 0 {
+		return "", false
+	}
+	for _, r := range f.allow {
+		if r.cidrs.Contains(a) {
+			return "", false
+		}
+	}
+	return RegionOther, true
+}
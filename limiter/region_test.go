@@ -0,0 +1,110 @@
+package limiter
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xtls/xray-core/app/router"
+	"google.golang.org/protobuf/proto"
+)
+
+func writeGeoIP(t *testing.T) {
+	dir := t.TempDir()
+	b, err := proto.Marshal(&router.GeoIPList{
+		Entry: []*router.GeoIP{
+			{
+				CountryCode: "AA",
+				Cidr: []*router.CIDR{
+					{Ip: []byte{10, 0, 0, 0}, Prefix: 8},
+					{Ip: []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, Prefix: 32},
+				},
+			},
+			{
+				CountryCode: "BB",
+				Cidr: []*router.CIDR{
+					{Ip: []byte{192, 168, 0, 0}, Prefix: 16},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, "geoip.dat"), b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XRAY_LOCATION_ASSET", dir)
+}
+
+func TestLimiter_CheckRegion(t *testing.T) {
+	writeGeoIP(t)
+	l := NewLimiter(0, 0, 0, nil, nil)
+	if _, reject := l.CheckRegion("10.0.0.1"); reject {
+		t.Error("no region filter should reject nothing")
+	}
+	if err := l.UpdateRegionFilter(RegionFilter{Deny: []string{"aa"}}); err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"10.1.2.3":      true,
+		"[2001:db8::1]": true,
+		"192.168.1.1":   false,
+		"8.8.8.8":       false,
+	} {
+		region, reject := l.CheckRegion(ip)
+		if reject != want {
+			t.Errorf("CheckRegion(%s) = %v, want %v", ip, reject, want)
+		}
+		if reject && region != "AA" {
+			t.Errorf("CheckRegion(%s) region = %s, want AA", ip, region)
+		}
+	}
+
+	if err := l.UpdateRegionFilter(RegionFilter{Allow: []string{"BB"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, reject := l.CheckRegion("192.168.1.1"); reject {
+		t.Error("allowed region should not be rejected")
+	}
+	if region, reject := l.CheckRegion("8.8.8.8"); !reject || region != RegionOther {
+		t.Errorf("CheckRegion(8.8.8.8) = %s %v, want %s true", region, reject, RegionOther)
+	}
+	if region, reject := l.CheckRegion("not an ip"); !reject || region != RegionOther {
+		t.Errorf("CheckRegion(not an ip) = %s %v, want %s true", region, reject, RegionOther)
+	}
+
+	if err := l.UpdateRegionFilter(RegionFilter{Deny: []string{"CC"}}); err == nil {
+		t.Error("unknown region should fail")
+	}
+	if got := l.GetRegionFilter(); len(got.Allow) != 1 || got.Allow[0] != "BB" {
+		t.Errorf("failed update should keep the filter, got %v", got)
+	}
+}
+
+func TestLoadGeoIP(t *testing.T) {
+	writeGeoIP(t)
+	sets, err := LoadGeoIP("aa", " bb ", "CC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 2 || !sets["AA"].Contains(netip.MustParseAddr("10.0.0.1")) ||
+		!sets["BB"].Contains(netip.MustParseAddr("192.168.0.1")) {
+		t.Errorf("LoadGeoIP() = %v", sets)
+	}
+}
+
+func TestLimiter_AddRegionReject(t *testing.T) {
+	l := NewLimiter(0, 0, 0, nil, nil)
+	l.AddRegionReject("AA")
+	l.AddRegionReject("AA")
+	l.AddRegionReject(RegionOther)
+	if got := l.GetRejects()[RejectRegion]; got != 3 {
+		t.Errorf("region rejects = %d, want 3", got)
+	}
+	rs := l.GetRegionRejects()
+	if rs["AA"] != 2 || rs[RegionOther] != 1 {
+		t.Errorf("GetRegionRejects() = %v", rs)
+	}
+}
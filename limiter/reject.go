@@ -0,0 +1,55 @@
+package limiter
+
+import (
+	"sync/atomic"
+
+	cmap "github.com/orcaman/concurrent-map/v2"
+)
+
+// Reasons of rejected connections
+const (
+	RejectIpLimit   = "ip_limit"
+	RejectConnLimit = "conn_limit"
+	RejectBanned    = "banned"
+	RejectSource    = "source"
+	RejectRegion    = "region"
+	RejectQuota     = "quota"
+)
+
+func addCount(m cmap.ConcurrentMap[string, *atomic.Int64], key string) {
+	m.Upsert(key, nil, func(exist bool, v *atomic.Int64, _ *atomic.Int64) *atomic.Int64 {
+		if exist {
+			return v
+		}
+		return new(atomic.Int64)
+	}).Add(1)
+}
+
+func loadCounts(m cmap.ConcurrentMap[string, *atomic.Int64]) map[string]int64 {
+	cs := make(map[string]int64, m.Count())
+	m.IterCb(func(key string, c *atomic.Int64) {
+		cs[key] = c.Load()
+	})
+	return cs
+}
+
+// AddReject counts a connection rejected for reason.
+func (l *Limiter) AddReject(reason string) {
+	addCount(l.rejects, reason)
+}
+
+// AddRegionReject counts a connection rejected by the region filter for region.
+func (l *Limiter) AddRegionReject(region string) {
+	addCount(l.rejects, RejectRegion)
+	addCount(l.regionRejects, region)
+}
+
+// GetRejects returns the number of rejected connections by reason.
+func (l *Limiter) GetRejects() map[string]int64 {
+	return loadCounts(l.rejects)
+}
+
+// GetRegionRejects returns the number of connections rejected by the region filter by region.
+func (l *Limiter) GetRegionRejects() map[string]int64 {
+	return loadCounts(l.regionRejects)
+}
@@ -0,0 +1,72 @@
+package limiter
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// SourceFilter is the lists of client source ips allowed and denied on a node,
+// every entry is a cidr or a single ip.
+// A source in Deny is always rejected, and a source not in Allow is rejected
+// unless Allow is empty.
+type SourceFilter struct {
+	Allow []string
+	Deny  []string
+}
+
+type sourceFilter struct {
+	raw   SourceFilter
+	allow *CidrSet
+	deny  *CidrSet
+}
+
+// UpdateSourceFilter replaces the source filter of the node.
+func (l *Limiter) UpdateSourceFilter(f SourceFilter) error {
+	allow, err := NewCidrSet(f.Allow)
+	if err != nil {
+		return err
+	}
+	deny, err := NewCidrSet(f.Deny)
+	if err != nil {
+		return err
+	}
+	l.sources.Store(&sourceFilter{
+		raw:   f,
+		allow: allow,
+		deny:  deny,
+	})
+	return nil
+}
+
+func (l *Limiter) GetSourceFilter() SourceFilter {
+	if f := l.sources.Load(); f != nil {
+		return f.raw
+	}
+	return SourceFilter{}
+}
+
+// CheckSource reports whether connections from the source ip should be rejected,
+// ipv6 addresses may be enclosed in brackets.
+// A source that is not an ip is rejected if Allow is not empty.
+func (l *Limiter) CheckSource(ip string) (reject bool) {
+	f := l.sources.Load()
+	if f == nil {
+		return false
+	}
+	a, ok := parseSourceIp(ip)
+	if !ok {
+		return len(f.raw.Allow) > 0
+	}
+	if f.deny.Contains(a) {
+		return true
+	}
+	return len(f.raw.Allow) > 0 && !f.allow.Contains(a)
+}
+
+func parseSourceIp(ip string) (netip.Addr, bool) {
+	a, err := netip.ParseAddr(strings.Trim(ip, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return a, true
+}
@@ -0,0 +1,89 @@
+package limiter
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+)
+
+func TestCidrSet(t *testing.T) {
+	s, err := NewCidrSet([]string{"10.0.0.0/8", "192.168.1.1", "2001:db8::/32", "10.1.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"10.2.3.4":    true,
+		"10.1.2.3":    true,
+		"11.0.0.1":    false,
+		"192.168.1.1": true,
+		"192.168.1.2": false,
+		"2001:db8::1": true,
+		"2001:db9::1": false,
+	} {
+		if got := s.Contains(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("Contains(%s) = %v, want %v", ip, got, want)
+		}
+	}
+	if !s.Contains(netip.MustParseAddr("::ffff:10.0.0.1")) {
+		t.Error("ipv4 mapped address should match ipv4 prefix")
+	}
+	if _, err = NewCidrSet([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("invalid cidr should be rejected")
+	}
+	if _, err = NewCidrSet([]string{"::ffff:0:0/80"}); err == nil {
+		t.Error("ipv4 mapped cidr shorter than /96 should be rejected")
+	}
+	s, err = NewCidrSet([]string{"::ffff:10.0.0.0/104"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Contains(netip.MustParseAddr("10.1.2.3")) || s.Contains(netip.MustParseAddr("11.0.0.1")) {
+		t.Error("ipv4 mapped cidr should match as the ipv4 prefix")
+	}
+}
+
+func TestLimiter_CheckSource(t *testing.T) {
+	l := NewLimiter(0, 0, 0, nil, nil)
+	if l.CheckSource("1.1.1.1") {
+		t.Fatal("sources should be allowed without filter")
+	}
+	err := l.UpdateSourceFilter(SourceFilter{
+		Allow: []string{"10.0.0.0/8", "2001:db8::/32"},
+		Deny:  []string{"10.0.0.0/24"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, reject := range map[string]bool{
+		"10.1.0.1":      false,
+		"10.0.0.1":      true,
+		"1.1.1.1":       true,
+		"[2001:db8::1]": false,
+		"[2001:db9::1]": true,
+		"not an ip":     true,
+	} {
+		if l.CheckSource(ip) != reject {
+			t.Errorf("CheckSource(%s) should be %v", ip, reject)
+		}
+	}
+	_ = l.UpdateSourceFilter(SourceFilter{Deny: []string{"10.0.0.0/8"}})
+	if l.CheckSource("not an ip") {
+		t.Error("source that is not an ip should be allowed without allow list")
+	}
+}
+
+func BenchmarkCidrSet_Contains(b *testing.B) {
+	cidrs := make([]string, 0, 10000)
+	for i := 0; i < 10000; i++ {
+		cidrs = append(cidrs, fmt.Sprintf("%d.%d.%d.0/24", 1+i/65536, i/256%256, i%256))
+	}
+	s, err := NewCidrSet(cidrs)
+	if err != nil {
+		b.Fatal(err)
+	}
+	a := netip.MustParseAddr("8.8.8.8")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Contains(a)
+	}
+}
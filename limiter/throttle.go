@@ -0,0 +1,168 @@
+package limiter
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultThrottleWindow is used when the window of ThrottleConfig is not set.
+const DefaultThrottleWindow = 24 * time.Hour
+
+// windowBuckets is the number of samples kept in a traffic window.
+const windowBuckets = 60
+
+// ThrottleTier lowers the speed of a user to SpeedLimit (bytes per second)
+// once the user passes Traffic bytes in the window.
+type ThrottleTier struct {
+	Traffic    uint64 `json:"Traffic"`
+	SpeedLimit uint64 `json:"SpeedLimit"`
+}
+
+// ThrottleConfig is a fair-use policy of tiered throttling.
+type ThrottleConfig struct {
+	// Window is the seconds of the rolling window the traffic is counted in
+	Window int            `json:"Window"`
+	Tiers  []ThrottleTier `json:"Tiers"`
+}
+
+func (c *ThrottleConfig) window() time.Duration {
+	if c.Window <= 0 {
+		return DefaultThrottleWindow
+	}
+	return time.Duration(c.Window) * time.Second
+}
+
+// speedFor returns the speed of the highest tier passed by traffic, zero if none.
+func (c *ThrottleConfig) speedFor(traffic uint64) uint64 {
+	var tier *ThrottleTier
+	for i := range c.Tiers {
+		if traffic >= c.Tiers[i].Traffic && (tier == nil || c.Tiers[i].Traffic > tier.Traffic) {
+			tier = &c.Tiers[i]
+		}
+	}
+	if tier == nil {
+		return 0
+	}
+	return tier.SpeedLimit
+}
+
+type trafficSample struct {
+	time  time.Time
+	total uint64
+}
+
+// trafficWindow counts the traffic of a user in a rolling window.
+type trafficWindow struct {
+	samples []trafficSample
+}
+
+// update feeds the traffic counted so far and returns the traffic in the window.
+func (w *trafficWindow) update(total uint64, now time.Time, window time.Duration) uint64 {
+	if len(w.samples) == 0 || now.Sub(w.samples[len(w.samples)-1].time) >= window/windowBuckets {
+		w.samples = append(w.samples, trafficSample{time: now, total: total})
+	}
+	// keep the newest sample older than the window as the base
+	i := sort.Search(len(w.samples), func(i int) bool {
+		return now.Sub(w.samples[i].time) < window
+	})
+	if i > 1 {
+		w.samples = append(w.samples[:0], w.samples[i-1:]...)
+	}
+	return total - w.samples[0].total
+}
+
+type userTraffic struct {
+	// counted is the traffic of the user since it is tracked
+	counted atomic.Uint64
+	access  sync.Mutex
+	window  trafficWindow
+	traffic uint64
+	speed   uint64
+}
+
+func newUserTraffic(now time.Time) *userTraffic {
+	return &userTraffic{
+		// the traffic starts at zero when the user is tracked
+		window: trafficWindow{samples: []trafficSample{{time: now}}},
+	}
+}
+
+func (l *Limiter) getUserTraffic(email string) *userTraffic {
+	if t, ok := l.userTraffic.Get(email); ok {
+		return t
+	}
+	return l.userTraffic.Upsert(email, nil, func(exist bool, v *userTraffic, _ *userTraffic) *userTraffic {
+		if exist {
+			return v
+		}
+		return newUserTraffic(time.Now())
+	})
+}
+
+// ThrottleStatus is the state of a user throttled by the fair-use policy.
+type ThrottleStatus struct {
+	User       string
+	Traffic    uint64
+	SpeedLimit uint64
+}
+
+// AddTraffic counts n bytes written by the connections of the user
+// for the throttle policy.
+func (l *Limiter) AddTraffic(email string, n int64) {
+	if l.Throttle == nil || len(l.Throttle.Tiers) == 0 || n <= 0 {
+		return
+	}
+	l.getUserTraffic(email).counted.Add(uint64(n))
+}
+
+// UpdateTraffic moves the windows of the users to the traffic counted by AddTraffic.
+// The speed of the connections of a user changes as soon as it enters or leaves a tier.
+func (l *Limiter) UpdateTraffic() {
+	if l.Throttle == nil || len(l.Throttle.Tiers) == 0 {
+		return
+	}
+	now := time.Now()
+	window := l.Throttle.window()
+	for _, email := range l.userLimit.Keys() {
+		t := l.getUserTraffic(email)
+		t.access.Lock()
+		t.traffic = t.window.update(t.counted.Load(), now, window)
+		speed := l.Throttle.speedFor(t.traffic)
+		changed := speed != t.speed
+		t.speed = speed
+		t.access.Unlock()
+		if changed {
+			l.applySpeedLimit(email)
+		}
+	}
+}
+
+func (l *Limiter) getThrottleSpeed(email string) uint64 {
+	t, ok := l.userTraffic.Get(email)
+	if !ok {
+		return 0
+	}
+	t.access.Lock()
+	defer t.access.Unlock()
+	return t.speed
+}
+
+// GetThrottled returns the users currently throttled by the fair-use policy.
+func (l *Limiter) GetThrottled() []ThrottleStatus {
+	var ss []ThrottleStatus
+	l.userTraffic.IterCb(func(email string, t *userTraffic) {
+		t.access.Lock()
+		defer t.access.Unlock()
+		if t.speed == 0 {
+			return
+		}
+		ss = append(ss, ThrottleStatus{
+			User:       email,
+			Traffic:    t.traffic,
+			SpeedLimit: t.speed,
+		})
+	})
+	return ss
+}
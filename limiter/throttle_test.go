@@ -0,0 +1,72 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/InazumaV/Ratte-Interface/params"
+	"golang.org/x/time/rate"
+)
+
+func TestTrafficWindow(t *testing.T) {
+	now := time.Now()
+	w := &trafficWindow{samples: []trafficSample{{time: now}}}
+	window := time.Hour
+	if used := w.update(100, now, window); used != 100 {
+		t.Fatalf("want 100 counted from the start, got %d", used)
+	}
+	if used := w.update(300, now.Add(10*time.Minute), window); used != 300 {
+		t.Fatalf("want 300, got %d", used)
+	}
+	// the first 300 bytes left the window
+	if used := w.update(350, now.Add(75*time.Minute), window); used != 50 {
+		t.Fatalf("want 50 after window passed, got %d", used)
+	}
+}
+
+func TestLimiter_UpdateTraffic(t *testing.T) {
+	l := NewLimiter(0, 0, 1000, nil, nil)
+	l.Throttle = &ThrottleConfig{
+		Tiers: []ThrottleTier{
+			{Traffic: 1000, SpeedLimit: 500},
+			{Traffic: 2000, SpeedLimit: 100},
+		},
+	}
+	_ = l.AddUserInfos("node", []params.UserInfo{{Name: "a"}})
+	email := "[a](node)"
+	rl := l.GetRateLimiter(email)
+	if rl == nil || rl.Limit() != 1000 {
+		t.Fatal("rate limiter should use the node speed limit")
+	}
+	l.UpdateTraffic()
+	l.AddTraffic(email, 1500)
+	l.UpdateTraffic()
+	if rl.Limit() != 500 {
+		t.Fatalf("want first tier speed, got %v", rl.Limit())
+	}
+	l.AddTraffic(email, 1000)
+	l.UpdateTraffic()
+	if rl.Limit() != 100 {
+		t.Fatalf("want second tier speed, got %v", rl.Limit())
+	}
+	if s := l.GetThrottled(); len(s) != 1 || s[0].Traffic != 2500 {
+		t.Fatalf("unexpected throttle status: %v", s)
+	}
+}
+
+func TestLimiter_UpdateTraffic_Unlimited(t *testing.T) {
+	l := NewLimiter(0, 0, 0, nil, nil)
+	l.Throttle = &ThrottleConfig{
+		Tiers: []ThrottleTier{{Traffic: 1000, SpeedLimit: 500}},
+	}
+	_ = l.AddUserInfos("node", []params.UserInfo{{Name: "a"}})
+	rl := l.GetRateLimiter("[a](node)")
+	if rl == nil || rl.Limit() != rate.Inf {
+		t.Fatal("unlimited user should get an unlimited rate limiter to throttle later")
+	}
+	l.AddTraffic("[a](node)", 1000)
+	l.UpdateTraffic()
+	if rl.Limit() != 500 {
+		t.Fatalf("want throttled speed, got %v", rl.Limit())
+	}
+}
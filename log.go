@@ -0,0 +1,181 @@
+package xray
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/InazumaV/Ratte-Core-Xray/rotate"
+	"github.com/goccy/go-json"
+	log "github.com/sirupsen/logrus"
+	applog "github.com/xtls/xray-core/app/log"
+	"github.com/xtls/xray-core/common"
+	xlog "github.com/xtls/xray-core/common/log"
+	"github.com/xtls/xray-core/common/serial"
+	coreConf "github.com/xtls/xray-core/infra/conf"
+)
+
+func init() {
+	// the event type is not used by xray, it forwards the logs to logrus
+	common.Must(applog.RegisterHandlerCreator(applog.LogType_Event,
+		func(applog.LogType, applog.HandlerCreatorOptions) (xlog.Handler, error) {
+			return logrusHandler{}, nil
+		}))
+}
+
+// LogConfig is the log config of xray with the sinks of logrus.
+// The logs of xray go to logrus unless a file is set for them.
+type LogConfig struct {
+	coreConf.LogConfig
+	// Sinks replace the output of logrus if not empty
+	Sinks []LogSink `json:"Sinks"`
+	// Format of logrus, "text" or "json", unchanged if empty
+	Format string `json:"Format"`
+}
+
+type LogSink struct {
+	// Type is "stderr", "file" or "syslog"
+	Type   string        `json:"Type"`
+	File   rotate.Config `json:"File"`
+	Syslog SyslogConfig  `json:"Syslog"`
+}
+
+type SyslogConfig struct {
+	// Network and Address of the syslog server, the local one if empty
+	Network string `json:"Network"`
+	Address string `json:"Address"`
+	Tag     string `json:"Tag"`
+}
+
+func parseLogConfig(raw []byte) (*LogConfig, error) {
+	c := &LogConfig{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Build builds the xray log config, the logs without a file are sent to logrus.
+func (c *LogConfig) Build() *applog.Config {
+	lc := c.LogConfig.Build()
+	if lc.ErrorLogType == applog.LogType_Console {
+		lc.ErrorLogType = applog.LogType_Event
+	}
+	if lc.AccessLogType == applog.LogType_Console {
+		lc.AccessLogType = applog.LogType_Event
+	}
+	return lc
+}
+
+// setupSinks sets the output of logrus to the sinks,
+// the returned closers must be closed when the sinks are no longer used.
+func (c *LogConfig) setupSinks(dataPath string) ([]io.Closer, error) {
+	switch c.Format {
+	case "":
+	case "text":
+		log.SetFormatter(&log.TextFormatter{})
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return nil, fmt.Errorf("unsupported log format: %s", c.Format)
+	}
+	if len(c.Sinks) == 0 {
+		return nil, nil
+	}
+	var ws []io.Writer
+	var cs []io.Closer
+	for _, s := range c.Sinks {
+		switch s.Type {
+		case "stderr":
+			ws = append(ws, os.Stderr)
+		case "file":
+			if s.File.Path == "" {
+				closeAll(cs)
+				return nil, errors.New("log file path is empty")
+			}
+			w := rotate.New(dataPath, s.File)
+			ws = append(ws, w)
+			cs = append(cs, w)
+		case "syslog":
+			w, err := newSyslogWriter(s.Syslog)
+			if err != nil {
+				closeAll(cs)
+				return nil, fmt.Errorf("open syslog error: %w", err)
+			}
+			ws = append(ws, w)
+			cs = append(cs, w)
+		default:
+			closeAll(cs)
+			return nil, fmt.Errorf("unsupported log sink: %s", s.Type)
+		}
+	}
+	log.SetOutput(io.MultiWriter(ws...))
+	return cs, nil
+}
+
+func closeAll(cs []io.Closer) {
+	for _, c := range cs {
+		_ = c.Close()
+	}
+}
+
+// xrayLogRe splits a log of xray into the session id, the component and the message.
+var xrayLogRe = regexp.MustCompile(`^(?:\[(\d+)\] )?(?:([\w./-]+): )?((?s).*)$`)
+
+// logrusHandler is a log handler of xray writing to logrus.
+type logrusHandler struct{}
+
+func (logrusHandler) Handle(msg xlog.Message) {
+	// masked messages keep the message they wrap
+	s := msg.String()
+	if m, ok := msg.(*applog.MaskedMsgWrapper); ok {
+		msg = m.Message
+	}
+	switch msg := msg.(type) {
+	case *xlog.GeneralMessage:
+		entry, message := entryOf(strings.TrimPrefix(s, serial.Concat("[", msg.Severity, "] ")))
+		switch msg.Severity {
+		case xlog.Severity_Error:
+			entry.Error(message)
+		case xlog.Severity_Warning:
+			entry.Warn(message)
+		case xlog.Severity_Info:
+			entry.Info(message)
+		default:
+			entry.Debug(message)
+		}
+	case *xlog.AccessMessage:
+		log.WithFields(log.Fields{
+			"component": "access",
+			"email":     msg.Email,
+			"detour":    msg.Detour,
+		}).Info(s)
+	case *xlog.DNSLog:
+		log.WithField("component", "dns").Info(s)
+	default:
+		log.Info(s)
+	}
+}
+
+func entryOf(content string) (*log.Entry, string) {
+	fields := log.Fields{}
+	m := xrayLogRe.FindStringSubmatch(content)
+	if m == nil {
+		return log.WithFields(fields), content
+	}
+	if m[1] != "" {
+		if id, err := strconv.ParseUint(m[1], 10, 32); err == nil {
+			fields["session"] = id
+		}
+	}
+	if m[2] != "" {
+		fields["component"] = m[2]
+	}
+	return log.WithFields(fields), m[3]
+}
@@ -0,0 +1,12 @@
+//go:build !windows && !plan9
+
+package xray
+
+import (
+	"io"
+	"log/syslog"
+)
+
+func newSyslogWriter(c SyslogConfig) (io.WriteCloser, error) {
+	return syslog.Dial(c.Network, c.Address, syslog.LOG_INFO|syslog.LOG_DAEMON, c.Tag)
+}
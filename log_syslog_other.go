@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package xray
+
+import (
+	"errors"
+	"io"
+)
+
+func newSyslogWriter(SyslogConfig) (io.WriteCloser, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
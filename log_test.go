@@ -0,0 +1,57 @@
+package xray
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/goccy/go-json"
+	log "github.com/sirupsen/logrus"
+	applog "github.com/xtls/xray-core/app/log"
+	xctx "github.com/xtls/xray-core/common/ctx"
+	"github.com/xtls/xray-core/common/errors"
+	xlog "github.com/xtls/xray-core/common/log"
+)
+
+func TestLogConfig_Build(t *testing.T) {
+	lc, err := parseLogConfig([]byte(`{"loglevel": "info", "error": "/tmp/error.log", "Sinks": [{"Type": "stderr"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lc.Sinks) != 1 || lc.LogLevel != "info" {
+		t.Fatalf("unexpected log config: %+v", lc)
+	}
+	c := lc.Build()
+	if c.AccessLogType != applog.LogType_Event {
+		t.Errorf("access log type = %v, want event", c.AccessLogType)
+	}
+	if c.ErrorLogType != applog.LogType_File {
+		t.Errorf("error log type = %v, want file", c.ErrorLogType)
+	}
+}
+
+func TestLogrusHandler(t *testing.T) {
+	out := &bytes.Buffer{}
+	log.SetOutput(out)
+	log.SetFormatter(&log.JSONFormatter{})
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFormatter(&log.TextFormatter{})
+	}()
+	xlog.RegisterHandler(logrusHandler{})
+	defer xlog.RegisterHandler(x.Server.GetFeature((*applog.Instance)(nil)).(*applog.Instance))
+
+	ctx := xctx.ContextWithID(context.Background(), 42)
+	errors.LogWarning(ctx, "test message")
+	var entry map[string]any
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("invalid log %q: %v", out.String(), err)
+	}
+	if entry["level"] != "warning" || entry["msg"] != "test message" || entry["session"] != float64(42) {
+		t.Errorf("unexpected log entry: %v", entry)
+	}
+	if c, _ := entry["component"].(string); c == "" {
+		t.Errorf("log entry has no component: %v", entry)
+	}
+}
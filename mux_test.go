@@ -0,0 +1,197 @@
+package xray
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/InazumaV/Ratte-Core-Xray/dispatcher"
+	"github.com/InazumaV/Ratte-Core-Xray/event"
+	"github.com/goccy/go-json"
+	"github.com/xtls/xray-core/common/buf"
+	"github.com/xtls/xray-core/common/mux"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/uuid"
+)
+
+// muxClient speaks mux.cool over a vless session, like a client with mux enabled.
+type muxClient struct {
+	t      *testing.T
+	conn   net.Conn
+	r      *bufio.Reader
+	header bool
+}
+
+func dialMux(t *testing.T, port int, id string) *muxClient {
+	u, err := uuid.ParseString(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:"+strconv.Itoa(port), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	// version, id, no addons, mux
+	req := append([]byte{0}, u.Bytes()...)
+	req = append(req, 0, 3)
+	if _, err = conn.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	return &muxClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *muxClient) write(meta mux.FrameMetadata, data string) {
+	b := buf.New()
+	defer b.Release()
+	if err := meta.WriteTo(b); err != nil {
+		c.t.Fatal(err)
+	}
+	if data != "" {
+		b.Write(binary.BigEndian.AppendUint16(nil, uint16(len(data))))
+		b.WriteString(data)
+	}
+	if _, err := c.conn.Write(b.Bytes()); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// open starts the stream id to 127.0.0.1:dest with data.
+func (c *muxClient) open(id uint16, dest int, data string) {
+	c.write(mux.FrameMetadata{
+		SessionID:     id,
+		SessionStatus: mux.SessionStatusNew,
+		Option:        mux.OptionData,
+		Target:        xnet.TCPDestination(xnet.LocalHostIP, xnet.Port(dest)),
+	}, data)
+}
+
+// end closes the stream id.
+func (c *muxClient) end(id uint16) {
+	c.write(mux.FrameMetadata{
+		SessionID:     id,
+		SessionStatus: mux.SessionStatusEnd,
+	}, "")
+}
+
+// read returns the data of the next frame carrying data for the stream id.
+func (c *muxClient) read(id uint16) string {
+	_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer c.conn.SetReadDeadline(time.Time{})
+	if !c.header {
+		// version, no addons
+		if _, err := io.ReadFull(c.r, make([]byte, 2)); err != nil {
+			c.t.Fatal(err)
+		}
+		c.header = true
+	}
+	for {
+		var meta mux.FrameMetadata
+		if err := meta.Unmarshal(c.r); err != nil {
+			c.t.Fatal(err)
+		}
+		if !meta.Option.Has(mux.OptionData) {
+			continue
+		}
+		var n uint16
+		if err := binary.Read(c.r, binary.BigEndian, &n); err != nil {
+			c.t.Fatal(err)
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			c.t.Fatal(err)
+		}
+		if meta.SessionID == id {
+			return string(data)
+		}
+	}
+}
+
+// muxConfig ends a stream as soon as one side of it is closed.
+const muxConfig = `{"Policy": {"uplinkOnly": 0, "downlinkOnly": 0}}`
+
+func TestXray_Mux_Tracker(t *testing.T) {
+	xr, port := startTestNode(t, t.TempDir(), muxConfig)
+	echo := echoServer(t)
+	c := dialMux(t, port, "a3482e88-686a-4a58-8126-99c9df64b7bf")
+	c.open(1, echo, "ping")
+	if data := c.read(1); data != "ping" {
+		t.Fatalf("echo = %q", data)
+	}
+	if cs := xr.GetConnections(&GetConnectionsParams{NodeName: "n1"}); len(cs) != 1 {
+		t.Fatalf("connections = %+v", cs)
+	}
+	c.end(1)
+	waitFor(t, "the stream to be removed", func() bool {
+		return len(xr.GetConnections(&GetConnectionsParams{NodeName: "n1"})) == 0
+	})
+	es, _ := xr.events.Poll(0, 0)
+	var offline bool
+	for _, e := range es {
+		if e.Type == event.TypeUserOffline && e.Node == "n1" && e.User == "u1" {
+			offline = true
+		}
+	}
+	if !offline {
+		t.Errorf("no offline event while the mux connection is open, events = %+v", es)
+	}
+}
+
+func TestXray_Mux_ConnLimit(t *testing.T) {
+	xr, port := startTestNode(t, t.TempDir(), muxConfig)
+	l, _ := xr.dispatcher.GetLimiter("n1")
+	l.ConnLimit = 1
+	echo := echoServer(t)
+	c := dialMux(t, port, "a3482e88-686a-4a58-8126-99c9df64b7bf")
+	for id := uint16(1); id <= 3; id++ {
+		c.open(id, echo, "ping")
+		if data := c.read(id); data != "ping" {
+			t.Fatalf("echo of stream %d = %q", id, data)
+		}
+		c.end(id)
+		waitFor(t, "the stream to release its connection", func() bool {
+			return l.GetConnCount("[u1](n1)") == 0
+		})
+	}
+}
+
+func TestXray_Mux_AccessLog(t *testing.T) {
+	dir := t.TempDir()
+	_, port := startTestNode(t, dir,
+		`{"Policy": {"uplinkOnly": 0, "downlinkOnly": 0}, "AccessLog": {"Path": "access.log"}}`)
+	echo := echoServer(t)
+	c := dialMux(t, port, "a3482e88-686a-4a58-8126-99c9df64b7bf")
+	c.open(1, echo, "ping")
+	if data := c.read(1); data != "ping" {
+		t.Fatalf("echo = %q", data)
+	}
+	time.Sleep(300 * time.Millisecond)
+	c.end(1)
+	ended := time.Now()
+	var records []dispatcher.AccessRecord
+	waitFor(t, "the access record of the stream", func() bool {
+		b, _ := os.ReadFile(filepath.Join(dir, "access.log"))
+		records = records[:0]
+		for _, line := range bytes.Split(bytes.TrimSpace(b), []byte("\n")) {
+			var r dispatcher.AccessRecord
+			if json.Unmarshal(line, &r) == nil {
+				records = append(records, r)
+			}
+		}
+		return len(records) > 0
+	})
+	r := records[0]
+	if len(records) != 1 || r.User != "u1" || r.Node != "n1" || r.Up != 4 || r.Down != 4 {
+		t.Fatalf("records = %+v", records)
+	}
+	if d := r.End.Sub(r.Start); d < 300*time.Millisecond || r.End.After(ended.Add(time.Second)) {
+		t.Errorf("stream lasted %s, ended %s after its end", d, r.End.Sub(ended))
+	}
+}
@@ -5,11 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"github.com/InazumaV/Ratte-Core-Xray/common"
+	"github.com/InazumaV/Ratte-Core-Xray/event"
 	"github.com/InazumaV/Ratte-Core-Xray/limiter"
 	e2 "github.com/InazumaV/Ratte-Interface/common/errors"
 	"github.com/InazumaV/Ratte-Interface/core"
 	"github.com/goccy/go-json"
 	mapS "github.com/mitchellh/mapstructure"
+	"github.com/orcaman/concurrent-map/v2"
 	"github.com/xtls/xray-core/common/net"
 	"github.com/xtls/xray-core/common/serial"
 	xc "github.com/xtls/xray-core/core"
@@ -134,12 +136,14 @@ func (c *Xray) getInboundConfig(
 }
 
 func (c *Xray) getOutboundConfig(name string, exp *ExpendNodeOptions) (outH *xc.OutboundHandlerConfig, err error) {
-	var rawC json.RawMessage
 	oc := &coreConf.OutboundDetourConfig{
 		Protocol: "freedom",
 	}
 	if len(exp.RawOutbound) > 0 {
-		err = json.Unmarshal(rawC, &oc)
+		err = json.Unmarshal(exp.RawOutbound, oc)
+		if err != nil {
+			return nil, err
+		}
 	}
 	oc.Tag = name
 	return oc.Build()
@@ -229,6 +233,47 @@ type ExpendNodeOptions struct {
 	SendIp      string          `mapstructure:"SendIp"`
 	RawOutbound json.RawMessage `mapstructure:"RawOutbound"`
 	RawInbound  json.RawMessage `mapstructure:"RawInbound"`
+	// AllowSources and DenySources are cidrs or ips of clients
+	AllowSources []string `mapstructure:"AllowSources"`
+	DenySources  []string `mapstructure:"DenySources"`
+	// AllowCountries and DenyCountries are country codes of geoip.dat
+	AllowCountries []string `mapstructure:"AllowCountries"`
+	DenyCountries  []string `mapstructure:"DenyCountries"`
+	// NodeSpeedLimit caps the total speed of the node in bytes per second in each direction
+	NodeSpeedLimit uint64 `mapstructure:"NodeSpeedLimit"`
+	// FailoverOutbounds are tags of outbounds used in order when the observatory
+	// finds the default outbound of the node dead
+	FailoverOutbounds []string `mapstructure:"FailoverOutbounds"`
+}
+
+// node is a node added by AddNode, kept to add it back when reloading.
+type node struct {
+	params  *core.AddNodeParams
+	limiter *limiter.Limiter
+	users   cmap.ConcurrentMap[string, core.UserInfo]
+}
+
+// nodeConfigs returns the options, the inbound and the default outbound of the node.
+func (c *Xray) nodeConfigs(p *core.AddNodeParams) (
+	expO *ExpendNodeOptions,
+	in *xc.InboundHandlerConfig,
+	out *xc.OutboundHandlerConfig,
+	err error,
+) {
+	expO = &ExpendNodeOptions{}
+	err = mapS.Decode(p.NodeInfo.Options, expO)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("unmarshal expend node options failed: %s", err)
+	}
+	in, err = c.getInboundConfig(p.Name, p.NodeInfo, expO, &p.TlsOptions)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("get inbound config error: %s", err)
+	}
+	out, err = c.getOutboundConfig(common.FormatDefaultOutboundName(p.Name), expO)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("get outbound config error: %s", err)
+	}
+	return expO, in, out, nil
 }
 
 func (c *Xray) AddNode(p *core.AddNodeParams) (err error) {
@@ -237,27 +282,56 @@ func (c *Xray) AddNode(p *core.AddNodeParams) (err error) {
 			err = e2.NewStringFromErr(err)
 		}
 	}()
-	expO := &ExpendNodeOptions{}
-	err = mapS.Decode(p.NodeInfo.Options, expO)
+	c.access.RLock()
+	defer c.access.RUnlock()
+	expO, in, out, err := c.nodeConfigs(p)
 	if err != nil {
-		return fmt.Errorf("unmarshal expend node options failed: %s", err)
+		return err
 	}
-	in, err := c.getInboundConfig(p.Name, p.NodeInfo, expO, &p.TlsOptions)
+	limit := p.NodeInfo.Limit
+	l := limiter.NewLimiter(
+		limit.IPLimit,
+		limit.ConnLimit,
+		limit.SpeedLimit,
+		p.NodeInfo.Rules,
+		c.ips)
+	l.GlobalIpLimit = c.config.Limiter.GlobalDeviceLimit
+	l.Throttle = c.config.Limiter.Throttle
+	l.Burst = c.config.Limiter.Burst
+	l.SetNodeSpeedLimit(expO.NodeSpeedLimit)
+	err = l.UpdateSourceFilter(limiter.SourceFilter{
+		Allow: expO.AllowSources,
+		Deny:  expO.DenySources,
+	})
 	if err != nil {
-		return fmt.Errorf("get inbound config error: %s", err)
+		return fmt.Errorf("update source filter error: %s", err)
 	}
-	out, err := c.getOutboundConfig(common.FormatDefaultOutboundName(p.Name), expO)
+	err = l.UpdateRegionFilter(limiter.RegionFilter{
+		Allow: expO.AllowCountries,
+		Deny:  expO.DenyCountries,
+	})
 	if err != nil {
-		return fmt.Errorf("get outbound config error: %s", err)
+		return fmt.Errorf("update region filter error: %s", err)
 	}
-	limit := p.NodeInfo.Limit
-	_ = c.dispatcher.AddLimiter(
-		p.Name,
-		limiter.NewLimiter(
-			limit.IPLimit,
-			limit.SpeedLimit,
-			p.NodeInfo.Rules),
-	)
+	_ = c.dispatcher.AddLimiter(p.Name, l)
+	if err = c.addNodeHandlers(in, out); err != nil {
+		return err
+	}
+	c.dispatcher.SetFailover(p.Name, expO.FailoverOutbounds)
+	c.nodes.Set(p.Name, &node{
+		params:  p,
+		limiter: l,
+		users:   cmap.New[core.UserInfo](),
+	})
+	c.events.Publish(event.Event{
+		Type: event.TypeNodeAdded,
+		Node: p.Name,
+	})
+	return nil
+}
+
+// addNodeHandlers adds the inbound and the default outbound of a node to xray.
+func (c *Xray) addNodeHandlers(in *xc.InboundHandlerConfig, out *xc.OutboundHandlerConfig) error {
 	rawInH, err := xc.CreateObject(c.Server, in)
 	if err != nil {
 		return err
@@ -281,7 +355,6 @@ func (c *Xray) AddNode(p *core.AddNodeParams) (err error) {
 	if err = c.ohm.AddHandler(context.Background(), handler); err != nil {
 		return fmt.Errorf("add outbound handler error: %s", err)
 	}
-	c.nodes.Set(p.Name, p.NodeInfo)
 	return nil
 }
 
@@ -291,6 +364,8 @@ func (c *Xray) DelNode(name string) (err error) {
 			err = e2.NewStringFromErr(err)
 		}
 	}()
+	c.access.RLock()
+	defer c.access.RUnlock()
 	err = c.ihm.RemoveHandler(context.Background(), name)
 	if err != nil {
 		return fmt.Errorf("remove inbound %s error: %v", name, err)
@@ -303,9 +378,14 @@ func (c *Xray) DelNode(name string) (err error) {
 	n, _ := c.nodes.Get(name)
 	c.nodes.Remove(name)
 	_ = c.dispatcher.RemoveLimiter(name)
-	err = c.delRulesRouting(n.Rules)
+	c.dispatcher.RemoveFailover(name)
+	err = c.delRulesRouting(n.params.NodeInfo.Rules)
 	if err != nil {
 		return fmt.Errorf("remove rules routing error: %v", err)
 	}
+	c.events.Publish(event.Event{
+		Type: event.TypeNodeRemoved,
+		Node: name,
+	})
 	return nil
 }
@@ -1,6 +1,11 @@
 package xray
 
-import "testing"
+import (
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+)
 
 func TestXray_addRulesRouting_AND_delRulesRouting(t *testing.T) {
 	rs := []string{"domain:a.com", "domain:b.com"}
@@ -13,3 +18,20 @@ func TestXray_addRulesRouting_AND_delRulesRouting(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestXray_getOutboundConfig(t *testing.T) {
+	oc, err := x.getOutboundConfig("n1_out", &ExpendNodeOptions{
+		RawOutbound: json.RawMessage(`{"protocol": "blackhole"}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oc.Tag != "n1_out" || !strings.HasSuffix(oc.ProxySettings.Type, "blackhole.Config") {
+		t.Errorf("outbound = %s %s", oc.Tag, oc.ProxySettings.Type)
+	}
+	if _, err = x.getOutboundConfig("n1_out", &ExpendNodeOptions{
+		RawOutbound: json.RawMessage(`{"protocol": `),
+	}); err == nil {
+		t.Error("built a broken RawOutbound")
+	}
+}
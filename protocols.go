@@ -7,8 +7,10 @@ import (
 	"errors"
 	"fmt"
 	"github.com/InazumaV/Ratte-Interface/core"
+	"github.com/InazumaV/Ratte-Interface/params"
 	"github.com/goccy/go-json"
 	coreConf "github.com/xtls/xray-core/infra/conf"
+	"strconv"
 )
 
 func parseV2rayInboundConfig(p *core.NodeInfo, inbound *coreConf.InboundDetourConfig) error {
@@ -20,7 +22,7 @@ func parseV2rayInboundConfig(p *core.NodeInfo, inbound *coreConf.InboundDetourCo
 			setsNUll = true
 		}
 	}
-	netSets := json.RawMessage{}
+	var netSets params.NetworkSettings
 	var netProtocol string
 	// Set vmess
 	switch p.Type {
@@ -36,9 +38,6 @@ func parseV2rayInboundConfig(p *core.NodeInfo, inbound *coreConf.InboundDetourCo
 			}
 			inbound.Settings = (*json.RawMessage)(&s)
 		}
-		if len(p.VLess.NetworkSettings) == 0 {
-			return nil
-		}
 		netSets = p.VLess.NetworkSettings
 		netProtocol = p.VLess.Network
 	case "vmess":
@@ -50,33 +49,50 @@ func parseV2rayInboundConfig(p *core.NodeInfo, inbound *coreConf.InboundDetourCo
 			}
 			inbound.Settings = (*json.RawMessage)(&s)
 		}
-		if len(p.VMess.NetworkSettings) == 0 {
-			return nil
-		}
 		netSets = p.VMess.NetworkSettings
 		netProtocol = p.VMess.Network
 	}
-	if len(netSets) == 0 {
-		return nil
-	}
 
 	tp := coreConf.TransportProtocol(netProtocol)
-	inbound.StreamSetting = &coreConf.StreamConfig{Network: &tp}
+	if inbound.StreamSetting == nil {
+		inbound.StreamSetting = &coreConf.StreamConfig{}
+	}
+	inbound.StreamSetting.Network = &tp
 	switch netProtocol {
 	case "tcp":
-		err := json.Unmarshal(netSets, &inbound.StreamSetting.TCPSettings)
-		if err != nil {
-			return fmt.Errorf("unmarshal tcp settings error: %s", err)
-		}
 	case "ws":
-		err := json.Unmarshal(netSets, &inbound.StreamSetting.WSSettings)
-		if err != nil {
-			return fmt.Errorf("unmarshal ws settings error: %s", err)
+		if inbound.StreamSetting.WSSettings != nil {
+			break
+		}
+		ws := netSets.Ws
+		var heartbeat uint64
+		if ws.HeartbeatPeriod != "" {
+			var err error
+			heartbeat, err = strconv.ParseUint(ws.HeartbeatPeriod, 10, 32)
+			if err != nil {
+				return fmt.Errorf("parse ws heartbeat period error: %s", err)
+			}
+		}
+		inbound.StreamSetting.WSSettings = &coreConf.WebSocketConfig{
+			Host:            ws.Host,
+			Path:            ws.Path,
+			Headers:         ws.Headers,
+			HeartbeatPeriod: uint32(heartbeat),
 		}
 	case "grpc":
-		err := json.Unmarshal(netSets, &inbound.StreamSetting.GRPCSettings)
-		if err != nil {
-			return fmt.Errorf("unmarshal grpc settings error: %s", err)
+		if inbound.StreamSetting.GRPCSettings != nil {
+			break
+		}
+		g := netSets.Grpc
+		inbound.StreamSetting.GRPCSettings = &coreConf.GRPCConfig{
+			Authority:           g.Authority,
+			ServiceName:         g.ServiceName,
+			MultiMode:           g.MultiMode,
+			IdleTimeout:         int32(g.IdleTimeout),
+			HealthCheckTimeout:  int32(g.HealthCheckTimeout),
+			PermitWithoutStream: g.PermitWithoutStream,
+			InitialWindowsSize:  int32(g.InitialWindowsSize),
+			UserAgent:           g.UserAgent,
 		}
 	default:
 		return errors.New("the network type is not vail")
@@ -0,0 +1,38 @@
+package xray
+
+import (
+	"testing"
+
+	"github.com/InazumaV/Ratte-Interface/core"
+	"github.com/InazumaV/Ratte-Interface/params"
+	coreConf "github.com/xtls/xray-core/infra/conf"
+)
+
+func TestParseV2rayInboundConfig(t *testing.T) {
+	in := &coreConf.InboundDetourConfig{}
+	err := parseV2rayInboundConfig(&core.NodeInfo{
+		Type: "vless",
+		VLess: &params.VLess{VMess: params.VMess{
+			Network: "ws",
+			NetworkSettings: params.NetworkSettings{
+				Ws: params.WsSettings{Path: "/ws", HeartbeatPeriod: "30"},
+			},
+		}},
+	}, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *in.StreamSetting.Network != "ws" || in.StreamSetting.WSSettings.Path != "/ws" ||
+		in.StreamSetting.WSSettings.HeartbeatPeriod != 30 {
+		t.Errorf("stream settings = %+v", in.StreamSetting)
+	}
+
+	in = &coreConf.InboundDetourConfig{}
+	err = parseV2rayInboundConfig(&core.NodeInfo{
+		Type:  "vmess",
+		VMess: &params.VMess{Network: "quic"},
+	}, in)
+	if err == nil {
+		t.Error("parsed an unsupported network")
+	}
+}
@@ -0,0 +1,144 @@
+package xray
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/InazumaV/Ratte-Core-Xray/common"
+	e2 "github.com/InazumaV/Ratte-Interface/common/errors"
+	"github.com/InazumaV/Ratte-Interface/core"
+	xc "github.com/xtls/xray-core/core"
+	statsFeature "github.com/xtls/xray-core/features/stats"
+)
+
+// Reload restarts xray with the config re-read, such as the files and
+// the environment variables in it. The nodes, users, limiters, traffic not
+// reset yet and the custom inbounds and outbounds are added back.
+// Limiter, AccessLog, ControlSocket and the log sinks are kept as they were
+// until the core is restarted.
+func (c *Xray) Reload() (err error) {
+	defer func() {
+		if err != nil {
+			err = e2.NewStringFromErr(err)
+		}
+	}()
+	// the other methods wait for the restart, and see either the old core or the new one
+	c.access.Lock()
+	defer c.access.Unlock()
+	if c.Server == nil {
+		return errors.New("core is not started")
+	}
+	cf, err := decodeXrayConfig(c.dataPath, c.rawConfig, false)
+	if err != nil {
+		return err
+	}
+	cf.Limiter = c.config.Limiter
+	cf.AccessLog = c.config.AccessLog
+	cf.ControlSocket = c.config.ControlSocket
+	// build it before closing the running one, so a broken config changes nothing
+	server, err := buildCore(c.dataPath, cf)
+	if err != nil {
+		return err
+	}
+	traffic := c.userTraffic()
+	err = c.restart(server)
+	if err != nil {
+		// serve the nodes with the last config again
+		old, e := buildCore(c.dataPath, c.config)
+		if e != nil {
+			return fmt.Errorf("start xray error: %w, rebuild the last one error: %s", err, e)
+		}
+		if e = c.restart(old); e != nil {
+			return fmt.Errorf("start xray error: %w, restart the last one error: %s", err, e)
+		}
+		return errors.Join(fmt.Errorf("start xray error: %w", err), c.restore(traffic))
+	}
+	c.config = cf
+	return c.restore(traffic)
+}
+
+// restart replaces c.Server with the server.
+func (c *Xray) restart(server *xc.Instance) error {
+	if c.Server != nil {
+		if err := c.Server.Close(); err != nil {
+			return fmt.Errorf("close xray error: %w", err)
+		}
+	}
+	c.Server = server
+	return c.startServer()
+}
+
+// userTraffic returns the traffic counters of the users by the name of them.
+func (c *Xray) userTraffic() map[string]int64 {
+	counters := make(map[string]int64)
+	for name, n := range c.nodes.Items() {
+		for u := range n.users.Items() {
+			for _, cn := range trafficCounterNames(name, u) {
+				if counter := c.shm.GetCounter(cn); counter != nil {
+					counters[cn] = counter.Value()
+				}
+			}
+		}
+	}
+	return counters
+}
+
+func trafficCounterNames(node, user string) []string {
+	email := common.FormatUserEmail(node, user)
+	return []string{
+		"user>>>" + email + ">>>traffic>>>uplink",
+		"user>>>" + email + ">>>traffic>>>downlink",
+	}
+}
+
+// restore adds the nodes, the users and the custom handlers back to c.Server,
+// and sets the traffic counters to the values before restarting.
+func (c *Xray) restore(traffic map[string]int64) error {
+	var errs []error
+	for name, n := range c.nodes.Items() {
+		if err := c.restoreNode(n); err != nil {
+			errs = append(errs, fmt.Errorf("restore node %s error: %w", name, err))
+		}
+	}
+	for name, v := range traffic {
+		counter, err := statsFeature.GetOrRegisterCounter(c.shm, name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("restore counter %s error: %w", name, err))
+			continue
+		}
+		counter.Set(v)
+	}
+	for tag, config := range c.customInbounds.Items() {
+		if err := c.addInbound(config); err != nil {
+			errs = append(errs, fmt.Errorf("restore inbound %s error: %w", tag, err))
+		}
+	}
+	for tag, config := range c.customOutbounds.Items() {
+		if err := c.addOutbound(config); err != nil {
+			errs = append(errs, fmt.Errorf("restore outbound %s error: %w", tag, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Xray) restoreNode(n *node) error {
+	expO, in, out, err := c.nodeConfigs(n.params)
+	if err != nil {
+		return err
+	}
+	// the limiter keeps the bans, quotas and throttles of the users
+	_ = c.dispatcher.AddLimiter(n.params.Name, n.limiter)
+	if err = c.addNodeHandlers(in, out); err != nil {
+		return err
+	}
+	c.dispatcher.SetFailover(n.params.Name, expO.FailoverOutbounds)
+	us := make([]core.UserInfo, 0, n.users.Count())
+	for _, u := range n.users.Items() {
+		us = append(us, u)
+	}
+	users, err := buildProtocolUsers(n.params.Name, n.params.NodeInfo, us)
+	if err != nil {
+		return err
+	}
+	return c.addProtocolUsers(n.params.Name, users)
+}
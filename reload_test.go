@@ -0,0 +1,134 @@
+package xray
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/InazumaV/Ratte-Core-Xray/api"
+	"github.com/InazumaV/Ratte-Core-Xray/common"
+	"github.com/InazumaV/Ratte-Interface/core"
+	statsFeature "github.com/xtls/xray-core/features/stats"
+)
+
+func TestXray_ListNodes_AND_ListUsers(t *testing.T) {
+	xr, port := startTestNode(t, t.TempDir(), "{}")
+	ns := xr.ListNodes()
+	if len(ns) != 1 || ns[0].Name != "n1" || ns[0].Port != port || ns[0].Users != 1 {
+		t.Errorf("nodes = %+v", ns)
+	}
+	us, err := xr.ListUsers(&ListUsersParams{NodeName: "n1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(us) != 1 || us[0].Name != "u1" {
+		t.Errorf("users = %+v", us)
+	}
+	online, err := xr.GetOnline(&GetOnlineParams{NodeName: "n1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(online) != 0 {
+		t.Errorf("online = %+v", online)
+	}
+	if _, err = xr.ListUsers(&ListUsersParams{NodeName: "n2"}); err == nil {
+		t.Error("listed the users of an unknown node")
+	}
+}
+
+func TestXray_Reload(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"outbound.json": `[{"tag": "direct", "protocol": "freedom"}]`,
+	})
+	xr, port := startTestNode(t, dir, `{"Outbound": "outbound.json"}`)
+	err := xr.AddOutbound(&AddOutboundParams{Config: `{"tag": "custom", "protocol": "freedom"}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	up := trafficCounterNames("n1", "u1")[0]
+	counter, err := statsFeature.GetOrRegisterCounter(xr.shm, up)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter.Set(100)
+
+	writeFiles(t, dir, map[string]string{
+		"outbound.json": `[{"tag": "direct", "protocol": "freedom"}, {"tag": "new", "protocol": "freedom"}]`,
+	})
+	if err = xr.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range []string{"new", "custom", common.FormatDefaultOutboundName("n1")} {
+		if xr.ohm.GetHandler(tag) == nil {
+			t.Errorf("no outbound %s after reload", tag)
+		}
+	}
+	if err = dial(port); err != nil {
+		t.Errorf("node is not listening after reload: %v", err)
+	}
+	if ns := xr.ListNodes(); len(ns) != 1 || ns[0].Users != 1 {
+		t.Errorf("nodes = %+v", ns)
+	}
+	if tr := xr.GetUserTraffic(&core.GetUserTrafficParams{NodeName: "n1", Username: "u1"}); tr.Up != 100 {
+		t.Errorf("traffic = %+v", tr)
+	}
+
+	// a broken config keeps the running one
+	writeFiles(t, dir, map[string]string{"outbound.json": `[{"tag": "bad", "protocol": "nope"}]`})
+	if err = xr.Reload(); err == nil {
+		t.Error("reloaded a broken config")
+	}
+	if xr.ohm.GetHandler("new") == nil {
+		t.Error("the running config is changed by a broken one")
+	}
+}
+
+func TestXray_Reload_Concurrent(t *testing.T) {
+	xr, _ := startTestNode(t, t.TempDir(), "{}")
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			err := xr.AddUsers(&core.AddUsersParams{
+				NodeName: "n1",
+				Users: []core.UserInfo{
+					{Name: fmt.Sprintf("c%d", i), Key: []string{"b3482e88-686a-4a58-8126-99c9df64b7bf"}},
+				},
+			})
+			if err != nil {
+				t.Error(err)
+			}
+			xr.ListNodes()
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		if err := xr.Reload(); err != nil {
+			t.Error(err)
+		}
+	}
+	wg.Wait()
+	if ns := xr.ListNodes(); len(ns) != 1 || ns[0].Users != 21 {
+		t.Errorf("nodes = %+v", ns)
+	}
+}
+
+func TestXray_ControlSocket(t *testing.T) {
+	dir := t.TempDir()
+	startTestNode(t, dir, `{"ControlSocket": "ctl.sock"}`)
+	if _, err := os.Stat(filepath.Join(dir, "ctl.sock")); err != nil {
+		t.Fatal(err)
+	}
+	var ns []NodeStatus
+	err := api.NewClient(api.Config{Listen: "unix:" + filepath.Join(dir, "ctl.sock")}).
+		Call("CustomMethod", &core.CustomMethodParams{Method: MethodListNodes}, &ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ns) != 1 || ns[0].Name != "n1" {
+		t.Errorf("nodes = %+v", ns)
+	}
+}
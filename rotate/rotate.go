@@ -0,0 +1,70 @@
+package rotate
+
+import (
+	"path/filepath"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Config is a log file rotated by size and time.
+type Config struct {
+	// Path of the file, relative to the data path if not absolute
+	Path string `json:"Path"`
+	// MaxSize is the megabytes of the file before it is rotated, 100 if zero
+	MaxSize int `json:"MaxSize"`
+	// MaxBackups is the number of rotated files kept, all if zero
+	MaxBackups int `json:"MaxBackups"`
+	// MaxAge is the days a rotated file is kept, forever if zero
+	MaxAge   int  `json:"MaxAge"`
+	Compress bool `json:"Compress"`
+	// Interval is the hours between rotations no matter the size, disabled if zero
+	Interval int `json:"Interval"`
+}
+
+// Writer writes to the file of a Config and rotates it.
+type Writer struct {
+	*lumberjack.Logger
+	done chan struct{}
+}
+
+// New creates a Writer, the file is opened on the first write.
+func New(dataPath string, c Config) *Writer {
+	path := c.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dataPath, path)
+	}
+	w := &Writer{
+		Logger: &lumberjack.Logger{
+			Filename:   path,
+			MaxSize:    c.MaxSize,
+			MaxBackups: c.MaxBackups,
+			MaxAge:     c.MaxAge,
+			Compress:   c.Compress,
+			LocalTime:  true,
+		},
+		done: make(chan struct{}),
+	}
+	if c.Interval > 0 {
+		go w.rotateEvery(time.Duration(c.Interval) * time.Hour)
+	}
+	return w
+}
+
+func (w *Writer) rotateEvery(d time.Duration) {
+	t := time.NewTicker(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-t.C:
+			_ = w.Rotate()
+		}
+	}
+}
+
+func (w *Writer) Close() error {
+	close(w.done)
+	return w.Logger.Close()
+}
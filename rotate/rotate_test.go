@@ -0,0 +1,35 @@
+package rotate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	dir := t.TempDir()
+	w := New(dir, Config{Path: "log/access.log"})
+	if _, err := w.Write([]byte("a\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("b\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "log", "access.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "b\n" {
+		t.Errorf("current file = %q, want %q", b, "b\n")
+	}
+	files, _ := os.ReadDir(filepath.Join(dir, "log"))
+	if len(files) != 2 {
+		t.Errorf("want the current and a rotated file, got %d files", len(files))
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/InazumaV/Ratte-Core-Xray/common"
 	"github.com/InazumaV/Ratte-Interface/common/errors"
 	"github.com/InazumaV/Ratte-Interface/core"
+	"github.com/InazumaV/Ratte-Interface/params"
 	"github.com/xtls/xray-core/common/protocol"
 	"github.com/xtls/xray-core/common/serial"
 	"github.com/xtls/xray-core/infra/conf"
@@ -64,27 +65,55 @@ func (c *Xray) AddUsers(p *core.AddUsersParams) (err error) {
 			err = errors.NewStringFromErr(err)
 		}
 	}()
-	users := make([]*protocol.User, 0)
-	ni, _ := c.nodes.Get(p.NodeName)
+	c.access.RLock()
+	defer c.access.RUnlock()
+	n, ok := c.nodes.Get(p.NodeName)
+	if !ok {
+		return fmt.Errorf("no such node: %s", p.NodeName)
+	}
+	users, err := buildProtocolUsers(p.NodeName, n.params.NodeInfo, p.Users)
+	if err != nil {
+		return err
+	}
+	if l, ok := c.dispatcher.GetLimiter(p.NodeName); ok {
+		err = l.AddUserInfos(p.NodeName, common.BuildSlice(p.Users, func(v core.UserInfo) params.UserInfo {
+			return params.UserInfo(v)
+		}))
+		if err != nil {
+			return err
+		}
+	}
+	if err = c.addProtocolUsers(p.NodeName, users); err != nil {
+		return err
+	}
+	for _, u := range p.Users {
+		n.users.Set(u.Name, u)
+	}
+	return nil
+}
+
+// buildProtocolUsers builds the users of xray for the protocol of the node.
+func buildProtocolUsers(nodeName string, ni *core.NodeInfo, us []core.UserInfo) ([]*protocol.User, error) {
+	var users []*protocol.User
 	switch ni.Type {
 	case "vmess":
-		users = common.BuildSlice[core.UserInfo, *protocol.User](p.Users, func(v core.UserInfo) *protocol.User {
+		users = common.BuildSlice[core.UserInfo, *protocol.User](us, func(v core.UserInfo) *protocol.User {
 			vmessAccount := &conf.VMessAccount{
 				ID:       v.Key[0],
 				Security: "auto",
 			}
-			return getProtocolUser(common.FormatUserEmail(p.NodeName, v.Name), vmessAccount.Build())
+			return getProtocolUser(common.FormatUserEmail(nodeName, v.Name), vmessAccount.Build())
 		})
 	case "vless":
-		users = common.BuildSlice[core.UserInfo, *protocol.User](p.Users, func(v core.UserInfo) *protocol.User {
+		users = common.BuildSlice[core.UserInfo, *protocol.User](us, func(v core.UserInfo) *protocol.User {
 			vlessAccount := &vless.Account{
 				Id: v.Key[0],
 			}
 			vlessAccount.Flow = ni.VLess.Flow
-			return getProtocolUser(common.FormatUserEmail(p.NodeName, v.Name), vlessAccount)
+			return getProtocolUser(common.FormatUserEmail(nodeName, v.Name), vlessAccount)
 		})
 	case "shadowsocks":
-		users = common.BuildSlice[core.UserInfo](p.Users, func(v core.UserInfo) *protocol.User {
+		users = common.BuildSlice[core.UserInfo](us, func(v core.UserInfo) *protocol.User {
 			var m proto.Message
 			if ni.Shadowsocks.ServerKey == "" {
 				ssAccount := &shadowsocks.Account{
@@ -105,19 +134,24 @@ func (c *Xray) AddUsers(p *core.AddUsersParams) (err error) {
 				}
 				m = ssAccount
 			}
-			return getProtocolUser(common.FormatUserEmail(p.NodeName, v.Name), m)
+			return getProtocolUser(common.FormatUserEmail(nodeName, v.Name), m)
 		})
 	case "trojan":
-		users = common.BuildSlice[core.UserInfo](p.Users, func(v core.UserInfo) *protocol.User {
+		users = common.BuildSlice[core.UserInfo](us, func(v core.UserInfo) *protocol.User {
 			trojanAccount := &trojan.Account{
 				Password: v.Key[0],
 			}
-			return getProtocolUser(common.FormatUserEmail(p.NodeName, v.Name), trojanAccount)
+			return getProtocolUser(common.FormatUserEmail(nodeName, v.Name), trojanAccount)
 		})
 	default:
-		return fmt.Errorf("unsupported node type: %s", ni.Type)
+		return nil, fmt.Errorf("unsupported node type: %s", ni.Type)
 	}
-	man, err := c.getUserManager(p.NodeName)
+	return users, nil
+}
+
+// addProtocolUsers adds the users to the inbound of the node.
+func (c *Xray) addProtocolUsers(nodeName string, users []*protocol.User) error {
+	man, err := c.getUserManager(nodeName)
 	if err != nil {
 		return fmt.Errorf("get user manager error: %s", err)
 	}
@@ -135,6 +169,12 @@ func (c *Xray) AddUsers(p *core.AddUsersParams) (err error) {
 }
 
 func (c *Xray) GetUserTraffic(p *core.GetUserTrafficParams) *core.GetUserTrafficResponse {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	return c.getUserTraffic(p)
+}
+
+func (c *Xray) getUserTraffic(p *core.GetUserTrafficParams) *core.GetUserTrafficResponse {
 	Rsp := &core.GetUserTrafficResponse{}
 	upName := "user>>>" + common.FormatUserEmail(p.NodeName, p.Username) + ">>>traffic>>>uplink"
 	downName := "user>>>" + common.FormatUserEmail(p.NodeName, p.Username) + ">>>traffic>>>downlink"
@@ -155,6 +195,8 @@ func (c *Xray) ResetUserTraffic(p *core.ResetUserTrafficParams) (err error) {
 			err = errors.NewStringFromErr(err)
 		}
 	}()
+	c.access.RLock()
+	defer c.access.RUnlock()
 	upName := "user>>>" + common.FormatUserEmail(p.NodeName, p.Username) + ">>>traffic>>uplink"
 	downName := "user>>>" + common.FormatUserEmail(p.NodeName, p.Username) + ">>>traffic>>>downlink"
 	upCounter := c.shm.GetCounter(upName)
@@ -174,21 +216,49 @@ func (c *Xray) DelUsers(p *core.DelUsersParams) (err error) {
 			err = errors.NewStringFromErr(err)
 		}
 	}()
+	c.access.RLock()
+	defer c.access.RUnlock()
+	return c.delUsers(p, c.config.KickDeletedUsers)
+}
+
+// KickUser interrupts every live connection of the user on the node.
+func (c *Xray) KickUser(nodeName, username string) (int, error) {
+	if nodeName == "" || username == "" {
+		return 0, fmt.Errorf("node name and username are required")
+	}
+	c.access.RLock()
+	defer c.access.RUnlock()
+	return c.dispatcher.KickUser(nodeName, common.FormatUserEmail(nodeName, username)), nil
+}
+
+func (c *Xray) delUsers(p *core.DelUsersParams, kick bool) (err error) {
 	userManager, err := c.getUserManager(p.NodeName)
 	if err != nil {
 		return fmt.Errorf("get user manager error: %s", err)
 	}
-	var up, down, user string
+	var up, down, email string
 	for i := range p.Users {
-		user = p.Users[i]
-		err = userManager.RemoveUser(context.Background(), user)
+		// xray finds users by their emails
+		email = common.FormatUserEmail(p.NodeName, p.Users[i])
+		err = userManager.RemoveUser(context.Background(), email)
 		if err != nil {
 			return err
 		}
-		up = "user>>>" + common.FormatUserEmail(p.NodeName, p.Users[i]) + ">>>traffic>>>uplink"
-		down = "user>>>" + common.FormatUserEmail(p.NodeName, p.Users[i]) + ">>>traffic>>>downlink"
+		up = "user>>>" + email + ">>>traffic>>>uplink"
+		down = "user>>>" + email + ">>>traffic>>>downlink"
 		c.shm.UnregisterCounter(up)
 		c.shm.UnregisterCounter(down)
+		if kick {
+			c.dispatcher.KickUser(p.NodeName, email)
+		}
+	}
+	if l, ok := c.dispatcher.GetLimiter(p.NodeName); ok {
+		l.DelUsers(p.NodeName, p.Users)
+	}
+	if n, ok := c.nodes.Get(p.NodeName); ok {
+		for _, u := range p.Users {
+			n.users.Remove(u)
+		}
 	}
 	return nil
 }
@@ -0,0 +1,107 @@
+package xray
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/InazumaV/Ratte-Interface/core"
+	"github.com/xtls/xray-core/common/uuid"
+)
+
+// dialVless opens a vless tcp session of the user with id to 127.0.0.1:dest
+// through the node on port, and checks that data goes through it.
+func dialVless(t *testing.T, port int, id string, dest int) net.Conn {
+	u, err := uuid.ParseString(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:"+strconv.Itoa(port), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	// version, id, no addons, tcp, port, ipv4 address
+	req := append([]byte{0}, u.Bytes()...)
+	req = append(req, 0, 1)
+	req = binary.BigEndian.AppendUint16(req, uint16(dest))
+	req = append(req, 1, 127, 0, 0, 1)
+	req = append(req, "ping"...)
+	if _, err = conn.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	// version, no addons, then the echo
+	rsp := make([]byte, 6)
+	if _, err = io.ReadFull(conn, rsp); err != nil {
+		t.Fatal(err)
+	}
+	if string(rsp[2:]) != "ping" {
+		t.Fatalf("echo = %q", rsp[2:])
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	return conn
+}
+
+func TestXray_DelUsers_Kick(t *testing.T) {
+	for name, del := range map[string]func(xr *Xray) error{
+		"KickDeletedUsers": func(xr *Xray) error {
+			return xr.DelUsers(&core.DelUsersParams{NodeName: "n1", Users: []string{"u1"}})
+		},
+		"CustomMethod": func(xr *Xray) error {
+			var reply any
+			return xr.CustomMethod(MethodDelUsers, &DelUsersParams{
+				NodeName: "n1",
+				Users:    []string{"u1"},
+				Kick:     true,
+			}, &reply)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			xr, port := startTestNode(t, t.TempDir(), "{}")
+			conn := dialVless(t, port, "a3482e88-686a-4a58-8126-99c9df64b7bf", echoServer(t))
+			l, _ := xr.dispatcher.GetLimiter("n1")
+			if n := l.GetConnCount("[u1](n1)"); n != 1 {
+				t.Fatalf("conns of u1 = %d", n)
+			}
+			if err := del(xr); err != nil {
+				t.Fatal(err)
+			}
+			_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if _, err := conn.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+				t.Errorf("session of the deleted user is not interrupted: %v", err)
+			}
+			waitFor(t, "the session to be removed", func() bool {
+				return len(xr.dispatcher.ListConns("n1", "")) == 0
+			})
+			if n := l.GetConnCount("[u1](n1)"); n != 0 {
+				t.Errorf("conns of the deleted user = %d", n)
+			}
+			if us, _ := xr.ListUsers(&ListUsersParams{NodeName: "n1"}); len(us) != 0 {
+				t.Errorf("users = %+v", us)
+			}
+			if err := del(xr); err == nil {
+				t.Error("deleted an unknown user")
+			}
+		})
+	}
+}
+
+func TestXray_DelUsers_KeepSessions(t *testing.T) {
+	xr, port := startTestNode(t, t.TempDir(), `{"KickDeletedUsers": false}`)
+	dialVless(t, port, "a3482e88-686a-4a58-8126-99c9df64b7bf", echoServer(t))
+	if err := xr.DelUsers(&core.DelUsersParams{NodeName: "n1", Users: []string{"u1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(xr.dispatcher.ListConns("n1", "")); n != 1 {
+		t.Errorf("sessions of the deleted user = %d, want them kept", n)
+	}
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
@@ -0,0 +1,225 @@
+package xray
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/InazumaV/Ratte-Core-Xray/common"
+	"github.com/InazumaV/Ratte-Core-Xray/limiter"
+	e2 "github.com/InazumaV/Ratte-Interface/common/errors"
+	"github.com/InazumaV/Ratte-Interface/core"
+	mapS "github.com/mitchellh/mapstructure"
+	applog "github.com/xtls/xray-core/app/log"
+	"github.com/xtls/xray-core/common/serial"
+	xc "github.com/xtls/xray-core/core"
+)
+
+// ConfigError is an error of the config at Path,
+// such as "Outbound[1]" or "Nodes[0].NodeInfo.Options.AllowSources".
+type ConfigError struct {
+	Path    string
+	Message string
+}
+
+// ConfigErrors is every error found in a config.
+type ConfigErrors []ConfigError
+
+func (e *ConfigErrors) add(path string, err error) {
+	*e = append(*e, ConfigError{Path: path, Message: err.Error()})
+}
+
+func (e ConfigErrors) Error() string {
+	s := make([]string, 0, len(e))
+	for _, ce := range e {
+		if ce.Path == "" {
+			s = append(s, ce.Message)
+			continue
+		}
+		s = append(s, ce.Path+": "+ce.Message)
+	}
+	return strings.Join(s, "\n")
+}
+
+type ValidateParams struct {
+	// Config is the xray config in json, yaml or toml,
+	// the config of the running core is validated if empty
+	// it is validated as it is, without reading files or the environment
+	Config string                `mapstructure:"Config"`
+	Nodes  []*core.AddNodeParams `mapstructure:"Nodes"`
+}
+
+// Validate builds the config and the nodes without starting them,
+// and returns every error found.
+// It leaves the running core and the process as they are,
+// so the assets in the config, such as geoip.dat, are looked up where the running core looks them up.
+func (c *Xray) Validate(p *ValidateParams) (ConfigErrors, error) {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	cf := c.config
+	if p.Config != "" {
+		var errs ConfigErrors
+		cf, errs = decodeValidateConfig("", []byte(p.Config), true)
+		if errs != nil {
+			return errs, nil
+		}
+	} else if cf == nil {
+		return nil, errors.New("config is empty and the core is not started")
+	}
+	return c.validate(cf, p.Nodes)
+}
+
+// decodeValidateConfig decodes the config to validate, the errors of it are returned as ConfigErrors.
+func decodeValidateConfig(dataPath string, config []byte, untrusted bool) (*XrayConfig, ConfigErrors) {
+	cf, err := decodeXrayConfig(dataPath, config, untrusted)
+	if err != nil {
+		var errs ConfigErrors
+		if !errors.As(err, &errs) {
+			errs.add("", err)
+		}
+		return nil, errs
+	}
+	return cf, nil
+}
+
+func (c *Xray) validate(cf *XrayConfig, nodes []*core.AddNodeParams) (errs ConfigErrors, err error) {
+	config, _, err := buildCoreConfig(cf)
+	if err != nil {
+		var ces ConfigErrors
+		if !errors.As(err, &ces) {
+			return nil, err
+		}
+		errs = append(errs, ces...)
+	} else {
+		// check the config the same way as starting, without listening.
+		// The log app is left out, it would replace the log handler of the running core
+		config.App = slices.DeleteFunc(config.App, func(m *serial.TypedMessage) bool {
+			return m.Type == logConfigType
+		})
+		server, err := xc.New(config)
+		if err != nil {
+			errs.add("", fmt.Errorf("new xray error: %w", err))
+		} else {
+			_ = server.Close()
+		}
+	}
+	names := make(map[string]int, len(nodes))
+	for i, n := range nodes {
+		path := fmt.Sprintf("Nodes[%d]", i)
+		if n == nil {
+			errs.add(path, errors.New("node is empty"))
+			continue
+		}
+		if j, ok := names[n.Name]; ok {
+			errs.add(path+".Name", fmt.Errorf("duplicate with Nodes[%d]", j))
+		} else {
+			names[n.Name] = i
+		}
+		errs = append(errs, c.validateNode(path, n)...)
+	}
+	return errs, nil
+}
+
+var logConfigType = serial.GetMessageType(&applog.Config{})
+
+func (c *Xray) validateNode(path string, p *core.AddNodeParams) (errs ConfigErrors) {
+	if p.NodeInfo == nil {
+		errs.add(path+".NodeInfo", errors.New("node info is empty"))
+		return errs
+	}
+	expO := &ExpendNodeOptions{}
+	err := mapS.Decode(p.NodeInfo.Options, expO)
+	if err != nil {
+		errs.add(path+".NodeInfo.Options", fmt.Errorf("unmarshal expend node options failed: %s", err))
+		return errs
+	}
+	err = func() (err error) {
+		// the options come from templates, a broken one must not break the caller
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		_, err = c.getInboundConfig(p.Name, p.NodeInfo, expO, &p.TlsOptions)
+		return err
+	}()
+	if err != nil {
+		errs.add(path+".Inbound", fmt.Errorf("get inbound config error: %s", err))
+	}
+	_, err = c.getOutboundConfig(common.FormatDefaultOutboundName(p.Name), expO)
+	if err != nil {
+		errs.add(path+".Outbound", fmt.Errorf("get outbound config error: %s", err))
+	}
+	opts := path + ".NodeInfo.Options"
+	for field, cidrs := range map[string][]string{
+		"AllowSources": expO.AllowSources,
+		"DenySources":  expO.DenySources,
+	} {
+		if _, err = limiter.NewCidrSet(cidrs); err != nil {
+			errs.add(opts+"."+field, err)
+		}
+	}
+	if len(expO.AllowCountries) == 0 && len(expO.DenyCountries) == 0 {
+		return errs
+	}
+	sets, err := limiter.LoadGeoIP(append(append([]string(nil), expO.AllowCountries...), expO.DenyCountries...)...)
+	if err != nil {
+		errs.add(opts, err)
+		return errs
+	}
+	for field, codes := range map[string][]string{
+		"AllowCountries": expO.AllowCountries,
+		"DenyCountries":  expO.DenyCountries,
+	} {
+		for j, code := range codes {
+			if _, ok := sets[strings.ToUpper(strings.TrimSpace(code))]; !ok {
+				errs.add(fmt.Sprintf("%s.%s[%d]", opts, field, j), errors.New("code not found in geoip.dat: "+code))
+			}
+		}
+	}
+	return errs
+}
+
+// decodeXrayConfig decodes the xray config in json, yaml or toml,
+// and loads the fields of it with the paths relative to dataPath.
+// An untrusted config, such as one from the api, is loaded without reading files or the environment.
+func decodeXrayConfig(dataPath string, config []byte, untrusted bool) (*XrayConfig, error) {
+	cf := NewXrayConfig()
+	err := decodeConfig("", config, cf)
+	if err != nil {
+		return nil, err
+	}
+	if err = cf.load(dataPath, untrusted); err != nil {
+		return nil, err
+	}
+	return cf, nil
+}
+
+// DecodeNodes decodes a list of AddNodeParams in json, yaml or toml,
+// the name is the file of them used in errors.
+func DecodeNodes(name string, data []byte) (ns []*core.AddNodeParams, err error) {
+	err = decodeConfig(name, data, &ns)
+	if err != nil {
+		return nil, err
+	}
+	return ns, nil
+}
+
+// ValidateConfig validates the config and the nodes without a running core.
+// Like starting a core, it makes the process look up the assets in the AssetPath of the config,
+// use Xray.Validate in a process with a running core.
+func ValidateConfig(dataPath string, config []byte, nodes []*core.AddNodeParams) (ConfigErrors, error) {
+	cf, errs := decodeValidateConfig(dataPath, config, false)
+	if errs != nil {
+		return errs, nil
+	}
+	if err := setAssetEnv(dataPath, cf); err != nil {
+		return nil, e2.NewStringFromErr(err)
+	}
+	errs, err := NewXray().validate(cf, nodes)
+	if err != nil {
+		return nil, e2.NewStringFromErr(err)
+	}
+	return errs, nil
+}
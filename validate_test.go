@@ -0,0 +1,124 @@
+package xray
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/InazumaV/Ratte-Interface/core"
+	"github.com/InazumaV/Ratte-Interface/params"
+	log "github.com/sirupsen/logrus"
+	"github.com/xtls/xray-core/common/errors"
+)
+
+func TestValidateConfig(t *testing.T) {
+	errs, err := ValidateConfig("./", []byte("{}"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Errorf("errors of the default config: %v", errs)
+	}
+}
+
+func TestValidateConfig_Errors(t *testing.T) {
+	config := `
+Outbound:
+  - protocol: nope
+    tag: a
+  - protocol: freedom
+    tag: b
+Route:
+  rules:
+    - type: field
+      outboundTag: b
+      ip: ["not an ip"]
+`
+	nodes := []*core.AddNodeParams{
+		{
+			Name: "n1",
+			NodeInfo: &core.NodeInfo{
+				Type: "unknown",
+				ExpandParams: params.ExpandParams{Options: map[string]any{
+					"AllowSources": []string{"10.0.0.0/8", "bad"},
+				}},
+			},
+		},
+		{Name: "n1"},
+		nil,
+	}
+	errs, err := ValidateConfig("./", []byte(config), nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	for _, e := range errs {
+		got[e.Path] = true
+	}
+	for _, path := range []string{
+		"Outbound[0]",
+		"Route",
+		"Nodes[0].Inbound",
+		"Nodes[0].NodeInfo.Options.AllowSources",
+		"Nodes[1].Name",
+		"Nodes[1].NodeInfo",
+		"Nodes[2]",
+	} {
+		if !got[path] {
+			t.Errorf("no error at %s, errors:\n%v", path, errs)
+		}
+	}
+}
+
+func TestXray_Validate(t *testing.T) {
+	errs, err := x.Validate(&ValidateParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Errorf("errors of the running config: %v", errs)
+	}
+}
+
+// logHook sends the messages logged by logrus to a channel.
+type logHook chan string
+
+func (logHook) Levels() []log.Level { return log.AllLevels }
+
+func (h logHook) Fire(e *log.Entry) error {
+	select {
+	case h <- e.Message:
+	default:
+	}
+	return nil
+}
+
+func TestXray_Validate_RunningCore(t *testing.T) {
+	xr, _ := startTestNode(t, t.TempDir(), "{}")
+	asset := os.Getenv("XRAY_LOCATION_ASSET")
+	errs, err := xr.Validate(&ValidateParams{Config: `{"AssetPath": "assets"}`})
+	if err != nil || len(errs) != 0 {
+		t.Fatal(errs, err)
+	}
+	if v := os.Getenv("XRAY_LOCATION_ASSET"); v != asset {
+		t.Errorf("asset location changed to %q", v)
+	}
+	logs := make(logHook, 16)
+	hooks := log.StandardLogger().ReplaceHooks(log.LevelHooks{})
+	defer log.StandardLogger().ReplaceHooks(hooks)
+	log.AddHook(logs)
+	errors.LogWarning(context.Background(), "after validate")
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case m := <-logs:
+			if strings.Contains(m, "after validate") {
+				return
+			}
+		case <-timeout:
+			t.Fatal("the logs of the running core are dropped after validating")
+		}
+	}
+}
@@ -2,13 +2,20 @@ package xray
 
 import (
 	"fmt"
+	"github.com/InazumaV/Ratte-Core-Xray/api"
 	"github.com/InazumaV/Ratte-Core-Xray/dispatcher"
+	"github.com/InazumaV/Ratte-Core-Xray/event"
+	"github.com/InazumaV/Ratte-Core-Xray/limiter"
+	"github.com/InazumaV/Ratte-Core-Xray/rotate"
 	"github.com/InazumaV/Ratte-Interface/common/errors"
 	"github.com/InazumaV/Ratte-Interface/core"
 	"github.com/goccy/go-json"
 	"github.com/orcaman/concurrent-map/v2"
 	log "github.com/sirupsen/logrus"
+	"github.com/xtls/xray-core/app/commander"
+	"github.com/xtls/xray-core/app/dns"
 	"github.com/xtls/xray-core/app/proxyman"
+	"github.com/xtls/xray-core/app/router"
 	"github.com/xtls/xray-core/app/stats"
 	"github.com/xtls/xray-core/common/serial"
 	xc "github.com/xtls/xray-core/core"
@@ -17,8 +24,11 @@ import (
 	"github.com/xtls/xray-core/features/routing"
 	statsFeature "github.com/xtls/xray-core/features/stats"
 	coreConf "github.com/xtls/xray-core/infra/conf"
+	"google.golang.org/protobuf/proto"
+	"io"
 	"os"
 	"path"
+	"path/filepath"
 	"sync"
 )
 
@@ -26,81 +36,81 @@ var _ core.Core = (*Xray)(nil)
 
 // Xray Structure
 type Xray struct {
-	access     sync.Mutex
+	// access guards the fields replaced by Start, Reload and Close,
+	// the other methods read them under the read lock
+	access     sync.RWMutex
 	Server     *xc.Instance
 	ihm        inbound.Manager
 	ohm        outbound.Manager
 	shm        statsFeature.Manager
 	ru         routing.Router
-	nodes      cmap.ConcurrentMap[string, *core.NodeInfo]
+	nodes      cmap.ConcurrentMap[string, *node]
 	dispatcher *dispatcher.DefaultDispatcher
-}
-
-func (c *Xray) CustomMethod(method string, args any, reply *any) error {
-	return nil
+	config     *XrayConfig
+	ips        limiter.IpStore
+	events     *event.Bus
+	accessLog  *rotate.Writer
+	logSinks   []io.Closer
+	dataPath   string
+	rawConfig  []byte
+	// customInbounds and customOutbounds are the configs added at runtime by tag
+	customInbounds  cmap.ConcurrentMap[string, string]
+	customOutbounds cmap.ConcurrentMap[string, string]
+	control         *api.Server
 }
 
 func NewXray() *Xray {
 	return &Xray{
-		nodes: cmap.New[*core.NodeInfo](),
+		nodes:           cmap.New[*node](),
+		events:          event.NewBus(event.DefaultBufferSize),
+		customInbounds:  cmap.New[string](),
+		customOutbounds: cmap.New[string](),
 	}
 }
 
-func buildCore(dataPath string, c *XrayConfig) (*xc.Instance, error) {
-	err := os.Setenv("XRAY_LOCATION_ASSET", path.Join(dataPath, c.AssetPath))
-	if err != nil {
-		return nil, err
-	}
+// buildCoreConfig builds the config of the xray instance,
+// the error is ConfigErrors with every error found in the config.
+func buildCoreConfig(c *XrayConfig) (*xc.Config, *LogConfig, error) {
+	var errs ConfigErrors
 	// Load log config
-	coreLogConfig := &coreConf.LogConfig{}
-	if len(c.Log) > 0 {
-		err = json.Unmarshal(c.Log, coreLogConfig)
-		if err != nil {
-			return nil, fmt.Errorf("decode log config error: %w", err)
-		}
+	coreLogConfig, err := parseLogConfig(c.Log)
+	if err != nil {
+		errs.add("Log", fmt.Errorf("decode log config error: %w", err))
 	}
 
 	// Load dns config
 	coreDnsConfig := &coreConf.DNSConfig{}
-	os.Setenv("XRAY_DNS_PATH", "")
-	if len(c.Dns) > 0 {
-		err = json.Unmarshal(c.Dns, coreDnsConfig)
-		if err != nil {
-			return nil, fmt.Errorf("decode dns config error: %w", err)
-		}
-	}
-	dnsConfig, err := coreDnsConfig.Build()
-	if err != nil {
-		return nil, fmt.Errorf("build dns config error: %w", err)
+	var dnsConfig *dns.Config
+	if err = unmarshalIfSet(c.Dns, coreDnsConfig); err != nil {
+		errs.add("Dns", fmt.Errorf("decode dns config error: %w", err))
+	} else if dnsConfig, err = coreDnsConfig.Build(); err != nil {
+		errs.add("Dns", fmt.Errorf("build dns config error: %w", err))
 	}
 
 	// Load route config
 	coreRouterConfig := &coreConf.RouterConfig{}
-	if len(c.Route) > 0 {
-		err = json.Unmarshal(c.Route, coreRouterConfig)
-		if err != nil {
-			return nil, fmt.Errorf("decode route config error: %w", err)
-		}
-	}
-	routeConfig, err := coreRouterConfig.Build()
-	if err != nil {
-		return nil, fmt.Errorf("build route config error: %w", err)
+	var routeConfig *router.Config
+	if err = unmarshalIfSet(c.Route, coreRouterConfig); err != nil {
+		errs.add("Route", fmt.Errorf("decode route config error: %w", err))
+	} else if routeConfig, err = coreRouterConfig.Build(); err != nil {
+		errs.add("Route", fmt.Errorf("build route config error: %w", err))
 	}
 
 	// Load inbound config
 	var coreCustomInboundConfig []coreConf.InboundDetourConfig
-	if len(coreCustomInboundConfig) > 0 {
+	if len(c.Inbound) > 0 {
 		err = json.Unmarshal(c.Inbound, &coreCustomInboundConfig)
 		if err != nil {
-			return nil, fmt.Errorf("decode inbound config error: %w", err)
+			errs.add("Inbound", fmt.Errorf("decode inbound config error: %w", err))
 		}
 	}
 	var inBoundConfig []*xc.InboundHandlerConfig
-	for _, config := range coreCustomInboundConfig {
+	for i, config := range coreCustomInboundConfig {
 		oc, err := config.Build()
 		if err != nil {
-			return nil, fmt.Errorf("build inbound(tag=%s) config error: %w",
-				config.Tag, err)
+			errs.add(fmt.Sprintf("Inbound[%d]", i),
+				fmt.Errorf("build inbound(tag=%s) config error: %w", config.Tag, err))
+			continue
 		}
 		inBoundConfig = append(inBoundConfig, oc)
 	}
@@ -110,15 +120,17 @@ func buildCore(dataPath string, c *XrayConfig) (*xc.Instance, error) {
 	if len(c.Outbound) > 0 {
 		err = json.Unmarshal(c.Outbound, &coreCustomOutboundConfig)
 		if err != nil {
-			return nil, fmt.Errorf("decode outbound config error: %w", err)
+			errs.add("Outbound", fmt.Errorf("decode outbound config error: %w", err))
 		}
 	}
 	var foundBlock bool
 	var outBoundConfig []*xc.OutboundHandlerConfig
-	for _, config := range coreCustomOutboundConfig {
+	for i, config := range coreCustomOutboundConfig {
 		oc, err := config.Build()
 		if err != nil {
-			return nil, fmt.Errorf("build outbound config error: %w", err)
+			errs.add(fmt.Sprintf("Outbound[%d]", i),
+				fmt.Errorf("build outbound(tag=%s) config error: %w", config.Tag, err))
+			continue
 		}
 		if config.Tag == "block" {
 			foundBlock = true
@@ -132,7 +144,7 @@ func buildCore(dataPath string, c *XrayConfig) (*xc.Instance, error) {
 			Tag:      "block",
 		}).Build()
 		if err != nil {
-			return nil, fmt.Errorf("build block outbound config error: %w", err)
+			errs.add("Outbound", fmt.Errorf("build block outbound config error: %w", err))
 		}
 		outBoundConfig = append(outBoundConfig, oc)
 	}
@@ -142,9 +154,34 @@ func buildCore(dataPath string, c *XrayConfig) (*xc.Instance, error) {
 	if len(c.Policy) > 0 {
 		err = json.Unmarshal(c.Policy, policy)
 		if err != nil {
-			return nil, fmt.Errorf("decode policy error: %w", err)
+			errs.add("Policy", fmt.Errorf("decode policy error: %w", err))
 		}
 	}
+	// Load api config
+	var apiConfig *commander.Config
+	if c.Api != nil {
+		apiConfig, err = c.Api.Build()
+		if err != nil {
+			errs.add("Api", fmt.Errorf("build api config error: %w", err))
+		}
+	}
+	// Load observatory config
+	var observatoryConfig proto.Message
+	var observatoryErr error
+	switch {
+	case c.Observatory != nil && c.BurstObservatory != nil:
+		errs.add("Observatory", fmt.Errorf("only one of Observatory and BurstObservatory can be set"))
+	case c.Observatory != nil:
+		observatoryConfig, observatoryErr = c.Observatory.Build()
+	case c.BurstObservatory != nil:
+		observatoryConfig, observatoryErr = c.BurstObservatory.Build()
+	}
+	if observatoryErr != nil {
+		errs.add("Observatory", fmt.Errorf("build observatory config error: %w", observatoryErr))
+	}
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
 	policy.StatsUserDownlink = true
 	policy.StatsUserUplink = true
 	policy.StatsUserOnline = true
@@ -166,6 +203,40 @@ func buildCore(dataPath string, c *XrayConfig) (*xc.Instance, error) {
 		Inbound:  inBoundConfig,
 		Outbound: outBoundConfig,
 	}
+	if apiConfig != nil {
+		config.App = append(config.App, serial.ToTypedMessage(apiConfig))
+	}
+	if observatoryConfig != nil {
+		config.App = append(config.App, serial.ToTypedMessage(observatoryConfig))
+	}
+	return config, coreLogConfig, nil
+}
+
+func unmarshalIfSet(raw []byte, v any) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// setAssetEnv makes xray look up the assets, such as geoip.dat, in the AssetPath of c.
+// It changes the environment of the whole process, so it is only called when starting a core.
+func setAssetEnv(dataPath string, c *XrayConfig) error {
+	err := os.Setenv("XRAY_LOCATION_ASSET", path.Join(dataPath, c.AssetPath))
+	if err != nil {
+		return err
+	}
+	return os.Setenv("XRAY_DNS_PATH", "")
+}
+
+func buildCore(dataPath string, c *XrayConfig) (*xc.Instance, error) {
+	if err := setAssetEnv(dataPath, c); err != nil {
+		return nil, err
+	}
+	config, coreLogConfig, err := buildCoreConfig(c)
+	if err != nil {
+		return nil, err
+	}
 	server, err := xc.New(config)
 	if err != nil {
 		return nil, fmt.Errorf("new xray error: %w", err)
@@ -192,17 +263,80 @@ func (c *Xray) Start(dataPath string, config []byte) (err error) {
 			err = errors.NewStringFromErr(err)
 		}
 	}()
-	var cf = NewXrayConfig()
-	err = json.Unmarshal(config, cf)
+	cf, err := decodeXrayConfig(dataPath, config, false)
 	if err != nil {
 		return err
 	}
-	c.Server, err = buildCore(dataPath, cf)
+	lc, err := parseLogConfig(cf.Log)
 	if err != nil {
-		return err
+		return fmt.Errorf("decode log config error: %w", err)
+	}
+	// built before anything is started, a broken store fails fast
+	ips, err := cf.Limiter.IpStore.Build()
+	if err != nil {
+		return fmt.Errorf("build ip store error: %w", err)
 	}
 	c.access.Lock()
 	defer c.access.Unlock()
+	logger := log.StandardLogger()
+	out, formatter := logger.Out, logger.Formatter
+	c.ips = ips
+	// undo everything set up so far if starting fails
+	defer func() {
+		if err == nil {
+			return
+		}
+		if c.control != nil {
+			_ = c.control.Close()
+			c.control = nil
+		}
+		if c.Server != nil {
+			_ = c.Server.Close()
+		}
+		c.Server, c.ihm, c.ohm, c.shm, c.ru, c.dispatcher = nil, nil, nil, nil, nil, nil
+		_ = c.ips.Close()
+		c.ips = nil
+		if c.accessLog != nil {
+			_ = c.accessLog.Close()
+			c.accessLog = nil
+		}
+		closeAll(c.logSinks)
+		c.logSinks = nil
+		log.SetOutput(out)
+		log.SetFormatter(formatter)
+		c.config, c.dataPath, c.rawConfig = nil, "", nil
+	}()
+	// set up before the core, so the logs of starting it go to the sinks too
+	c.logSinks, err = lc.setupSinks(dataPath)
+	if err != nil {
+		return fmt.Errorf("set up log sinks error: %w", err)
+	}
+	c.Server, err = buildCore(dataPath, cf)
+	if err != nil {
+		return err
+	}
+	c.config = cf
+	c.dataPath = dataPath
+	c.rawConfig = config
+	if cf.AccessLog != nil {
+		c.accessLog = rotate.New(dataPath, *cf.AccessLog)
+	}
+	if err = c.startServer(); err != nil {
+		return err
+	}
+	if cf.ControlSocket != "" {
+		c.control = api.NewServer(c, api.Config{
+			Listen: "unix:" + filepath.Join(dataPath, cf.ControlSocket),
+		})
+		if err = c.control.Start(); err != nil {
+			return fmt.Errorf("start control socket error: %w", err)
+		}
+	}
+	return nil
+}
+
+// startServer starts c.Server and gets the features of it.
+func (c *Xray) startServer() error {
 	if err := c.Server.Start(); err != nil {
 		return err
 	}
@@ -211,6 +345,10 @@ func (c *Xray) Start(dataPath string, config []byte) (err error) {
 	c.ohm = c.Server.GetFeature(outbound.ManagerType()).(outbound.Manager)
 	c.ru = c.Server.GetFeature(routing.RouterType()).(routing.Router)
 	c.dispatcher = c.Server.GetFeature(routing.DispatcherType()).(*dispatcher.DefaultDispatcher)
+	c.dispatcher.SetEventBus(c.events)
+	if c.accessLog != nil {
+		c.dispatcher.SetAccessLog(c.accessLog)
+	}
 	return nil
 }
 
@@ -221,6 +359,10 @@ func (c *Xray) Close() (err error) {
 			err = errors.NewStringFromErr(err)
 		}
 	}()
+	if c.control != nil {
+		_ = c.control.Close()
+		c.control = nil
+	}
 	c.access.Lock()
 	defer c.access.Unlock()
 	c.ihm = nil
@@ -231,6 +373,24 @@ func (c *Xray) Close() (err error) {
 	if err != nil {
 		return err
 	}
+	if c.ips != nil {
+		err = c.ips.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if c.accessLog != nil {
+		err = c.accessLog.Close()
+		c.accessLog = nil
+		if err != nil {
+			return err
+		}
+	}
+	if len(c.logSinks) > 0 {
+		log.SetOutput(os.Stderr)
+		closeAll(c.logSinks)
+		c.logSinks = nil
+	}
 	return nil
 }
 